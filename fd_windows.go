@@ -0,0 +1,6 @@
+//go:build windows
+
+package main
+
+// countOpenFiles is not supported on Windows and always returns -1.
+func countOpenFiles() int { return -1 }
@@ -8,8 +8,15 @@ import (
 )
 
 type AppConfig struct {
-	// Path to the GitHub App private key file (relative to config directory)
-	PrivateKey string `pkl:"privateKey" json:"privateKey"`
+	// Config schema version this file was written for. Unset means the latest.
+	SchemaVersion *int `pkl:"schemaVersion" json:"schemaVersion"`
+
+	// Path to the GitHub App private key file (relative to config directory).
+	// Required for GitHub App auth unless privateKeyPem is set.
+	PrivateKey *string `pkl:"privateKey" json:"privateKey"`
+
+	// The GitHub App private key itself, in PEM form. Wins over privateKey.
+	PrivateKeyPem *string `pkl:"privateKeyPem" json:"privateKeyPem"`
 
 	// GitHub App ID (numeric). If set, uses App ID authentication
 	// and auto-discovers installations. Takes precedence over clientId/installationId.
@@ -21,8 +28,171 @@ type AppConfig struct {
 	// GitHub App Installation ID (required when appId is not set)
 	InstallationId *int `pkl:"installationId" json:"installationId"`
 
+	// Additional GitHub Apps, each serving the owners it lists. Owners not listed
+	// by any app use the top-level app.
+	Apps []*GithubApp `pkl:"apps" json:"apps"`
+
+	// Fine-grained personal access token used for every repo instead of a GitHub App.
+	// Only used when neither appId nor clientId is set.
+	Token *string `pkl:"token" json:"token"`
+
+	// File holding the personal access token (relative to config directory), as an
+	// alternative to token.
+	TokenFile *string `pkl:"tokenFile" json:"tokenFile"`
+
+	// Remember which installation serves each owner in installations.json in the config
+	// directory, so restarts skip installation discovery. Tokens themselves are never stored.
+	InstallationCache bool `pkl:"installationCache" json:"installationCache"`
+
+	// "owner/repo" entries whose installation and token are resolved in the background
+	// at startup, so the first request doesn't pay for it.
+	Prewarm []string `pkl:"prewarm" json:"prewarm"`
+
+	// Personal access tokens to use instead of the GitHub App for specific owners,
+	// keyed by owner login. Use `read("env:...")` to keep tokens out of the file.
+	OwnerTokens map[string]string `pkl:"ownerTokens" json:"ownerTokens"`
+
 	// Listen address for the local proxy server (default: localhost:9443)
 	ListenAddress string `pkl:"listenAddress" json:"listenAddress"`
+
+	// Refuse to start unless listenAddress is a loopback address, so private-repo
+	// downloads aren't exposed to the network by accident.
+	LoopbackOnly bool `pkl:"loopbackOnly" json:"loopbackOnly"`
+
+	// TLS certificate (PEM) to serve HTTPS with, relative to the config directory.
+	// Must be set together with tlsKeyFile. See tlsMinVersion and tlsCipherSuites for
+	// what clients must support.
+	TlsCertFile *string `pkl:"tlsCertFile" json:"tlsCertFile"`
+
+	// TLS private key (PEM) for tlsCertFile, relative to the config directory.
+	TlsKeyFile *string `pkl:"tlsKeyFile" json:"tlsKeyFile"`
+
+	// Oldest TLS version clients may connect with. Versions before 1.2 are insecure
+	// and can't be enabled.
+	TlsMinVersion string `pkl:"tlsMinVersion" json:"tlsMinVersion"`
+
+	// Cipher suites offered to TLS 1.2 clients, by their Go names, e.g.
+	// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256". Suites Go considers insecure are
+	// rejected. Empty offers only the forward-secret AEAD suites. TLS 1.3 suites
+	// aren't configurable.
+	TlsCipherSuites []string `pkl:"tlsCipherSuites" json:"tlsCipherSuites"`
+
+	// Secret clients must send as "Authorization: Bearer <token>". Unset lets anyone
+	// who can reach listenAddress use the proxy.
+	ProxyAuthToken *string `pkl:"proxyAuthToken" json:"proxyAuthToken"`
+
+	// Hosts the asset downloader may follow redirects to, e.g. "*.githubusercontent.com".
+	// A leading "*." matches any subdomain. Empty allows any host.
+	AllowedRedirectHosts []string `pkl:"allowedRedirectHosts" json:"allowedRedirectHosts"`
+
+	// Repos the proxy serves, as "owner/repo" or "owner/*" patterns, so one shared
+	// proxy can't be used to read other repos its credentials reach. Other repos get a
+	// 403 without calling GitHub. Empty allows every repo.
+	AllowedRepos []string `pkl:"allowedRepos" json:"allowedRepos"`
+
+	// Maximum number of requests served at once. Unset means unlimited.
+	MaxInFlightRequests *int `pkl:"maxInFlightRequests" json:"maxInFlightRequests"`
+
+	// Requests allowed to wait for a free slot once maxInFlightRequests is reached.
+	// Requests beyond this are rejected with 503.
+	MaxQueuedRequests int `pkl:"maxQueuedRequests" json:"maxQueuedRequests"`
+
+	// What to do when a release has more than one asset with the requested name:
+	// "error" rejects the request, "newest" serves the most recently updated asset.
+	DuplicateAssets string `pkl:"duplicateAssets" json:"duplicateAssets"`
+
+	// Minimum level of proxy log lines: "debug", "info", "warn" or "error". Unset means
+	// "info" for the daemon and "warn" for run, to keep the command's output clean.
+	LogLevel *string `pkl:"logLevel" json:"logLevel"`
+
+	// Log line format: "text" or "json".
+	LogFormat string `pkl:"logFormat" json:"logFormat"`
+
+	// How often to log progress while streaming an asset. Unset disables progress logging.
+	ProgressInterval *string `pkl:"progressInterval" json:"progressInterval"`
+
+	// Log level for progress lines: "debug" or "info".
+	ProgressLogLevel string `pkl:"progressLogLevel" json:"progressLogLevel"`
+
+	// Size in bytes from which an asset logs progress every 10 MiB while streaming,
+	// and a summary of bytes and duration when it finishes. Unset disables this.
+	ProgressThreshold *int `pkl:"progressThreshold" json:"progressThreshold"`
+
+	// How long shutdown waits for in-flight downloads to finish before cutting them off.
+	ShutdownTimeout string `pkl:"shutdownTimeout" json:"shutdownTimeout"`
+
+	// How often the daemon samples its goroutine and open file counts. Unset disables monitoring.
+	MonitorInterval *string `pkl:"monitorInterval" json:"monitorInterval"`
+
+	// How often the daemon logs token cache statistics: cached token sources, the
+	// hit ratio and installation lookups since the last report. Unset disables them.
+	TokenStatsInterval *string `pkl:"tokenStatsInterval" json:"tokenStatsInterval"`
+
+	// Warn when the daemon has more goroutines than this.
+	MaxGoroutines *int `pkl:"maxGoroutines" json:"maxGoroutines"`
+
+	// Warn when the daemon has more open files than this (Unix only).
+	MaxOpenFiles *int `pkl:"maxOpenFiles" json:"maxOpenFiles"`
+
+	// Name of a release's primary artifact, served by the tag-only route when no asset
+	// is named after the tag. "{repo}" and "{tag}" are replaced, e.g. "{repo}-{tag}.tar.gz".
+	PrimaryAssetTemplate *string `pkl:"primaryAssetTemplate" json:"primaryAssetTemplate"`
+
+	// Asset names to look up in place of a requested file name, keyed by that name,
+	// so URIs stay stable when publishers rename assets. "{repo}", "{tag}", "{version}"
+	// (the tag without a leading "v"), "{os}" and "{arch}" are replaced; os and arch
+	// come from the ?os= and ?arch= query parameters, or the X-Pkl-Proxy-Os and
+	// X-Pkl-Proxy-Arch headers.
+	AssetNameTemplates map[string]string `pkl:"assetNameTemplates" json:"assetNameTemplates"`
+
+	// Check assets against their "<asset>.sha256" sibling, when the release has one,
+	// before serving them. Verified assets are downloaded to a temporary file first.
+	VerifyChecksums bool `pkl:"verifyChecksums" json:"verifyChecksums"`
+
+	// Treat requested file names containing glob metacharacters ("*", "?", "[") as
+	// patterns when no asset has that exact name, e.g. "mytool-*-linux-amd64".
+	// A pattern must match exactly one asset.
+	AssetGlobs bool `pkl:"assetGlobs" json:"assetGlobs"`
+
+	// Serve assets from draft releases, matched by tag name or release name, when
+	// no published release has the requested tag.
+	IncludeDrafts bool `pkl:"includeDrafts" json:"includeDrafts"`
+
+	// Maximum number of releases to page through when resolving a release from the
+	// release list (e.g. drafts). Bounds latency on repos with thousands of releases.
+	MaxReleasesScanned int `pkl:"maxReleasesScanned" json:"maxReleasesScanned"`
+
+	// Limit on connecting to GitHub and waiting for its response headers, and on whole
+	// API metadata calls. Asset bodies may take longer to stream.
+	UpstreamTimeout string `pkl:"upstreamTimeout" json:"upstreamTimeout"`
+
+	// Attempts made at a GitHub request that fails with a 5xx or a connection error,
+	// with exponential backoff between them. 1 disables retries.
+	MaxUpstreamAttempts int `pkl:"maxUpstreamAttempts" json:"maxUpstreamAttempts"`
+
+	// Most GitHub requests in progress at once, counting asset downloads until they
+	// finish. Further requests wait their turn.
+	MaxConcurrentUpstream int `pkl:"maxConcurrentUpstream" json:"maxConcurrentUpstream"`
+
+	// Largest asset, source archive or repo file, in bytes, the proxy will serve.
+	// Bigger ones get a 413, and a download that turns out bigger than this
+	// mid-stream is cut off. Unset is unlimited.
+	MaxAssetSize *int `pkl:"maxAssetSize" json:"maxAssetSize"`
+
+	// How long release metadata is cached and revalidated with ETags before it is
+	// fetched afresh. "0s" disables the cache.
+	ReleaseCacheTTL string `pkl:"releaseCacheTTL" json:"releaseCacheTTL"`
+
+	// For HEAD requests on assets whose metadata has no size, ask upstream for the
+	// length with an extra request. When false, Content-Length is omitted instead.
+	ProbeAssetSize bool `pkl:"probeAssetSize" json:"probeAssetSize"`
+
+	// Serve Prometheus metrics on /metrics.
+	Metrics bool `pkl:"metrics" json:"metrics"`
+
+	// Path of a unix socket on which lifecycle events (starting, ready, shutting down,
+	// stopped) are published as JSON lines. Unset disables the socket.
+	StatusSocket *string `pkl:"statusSocket" json:"statusSocket"`
 }
 
 // LoadFromPath loads the pkl module at the given path and evaluates it into a AppConfig
@@ -5,4 +5,5 @@ import "github.com/apple/pkl-go/pkl"
 
 func init() {
 	pkl.RegisterStrictMapping("pkl_proxy.AppConfig", AppConfig{})
+	pkl.RegisterStrictMapping("pkl_proxy.AppConfig#GithubApp", GithubApp{})
 }
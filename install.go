@@ -2,18 +2,27 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"text/template"
+
+	"github.com/bmurray/pkl-proxy/gen/appconfig"
+	"github.com/bmurray/pkl-proxy/pkg/proxy"
 )
 
 const rewritesPklTemplate = `// Auto-generated by pkl-proxy. Do not edit manually.
 // Run "pkl-proxy install <path>" or "pkl-proxy uninstall <path>" to manage entries.
 
 local listenAddress = read?("env:PKL_PROXY_LISTEN_ADDRESS") ?? "{{ .ListenAddress }}"
+local baseURL = if (listenAddress.contains("://")) listenAddress else "http://\(listenAddress)"
 
 local paths: Listing<String> = new {
 {{- range .Paths }}
@@ -23,15 +32,16 @@ local paths: Listing<String> = new {
 
 rewrites: Mapping<String, String> = new {
   for (path in paths) {
-    ["https://github.com/\(path)/"] = "http://\(listenAddress)/\(path)/"
-    ["https://pkg.pkl-lang.org/github.com/\(path)/"] = "http://\(listenAddress)/\(path)/"
+    ["https://github.com/\(path)/"] = "\(baseURL)/\(path)/"
+    ["https://pkg.pkl-lang.org/github.com/\(path)/"] = "\(baseURL)/\(path)/"
   }
 }
 `
 
+// settingsPklTemplate is a fresh settings.pkl; %s is the rewrites import line.
 const settingsPklTemplate = `amends "pkl:settings"
 
-import "pkl-proxy/rewrites.pkl" as pklProxy
+%s
 
 http {
   rewrites {
@@ -44,17 +54,14 @@ http {
 
 var rewritesTmpl = template.Must(template.New("rewrites").Parse(rewritesPklTemplate))
 
-// rewritesDir returns ~/.pkl/pkl-proxy/, creating it if needed.
+// rewritesDir returns ~/.pkl/pkl-proxy/. It doesn't create the directory, so
+// read-only commands leave no trace; writeRewritesPkl does that.
 func rewritesDir() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return "", fmt.Errorf("getting home directory: %w", err)
 	}
-	dir := filepath.Join(homeDir, ".pkl", "pkl-proxy")
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return "", fmt.Errorf("creating rewrites directory: %w", err)
-	}
-	return dir, nil
+	return filepath.Join(homeDir, ".pkl", "pkl-proxy"), nil
 }
 
 func rewritesFilePath() (string, error) {
@@ -65,7 +72,23 @@ func rewritesFilePath() (string, error) {
 	return filepath.Join(dir, "rewrites.pkl"), nil
 }
 
-func settingsFilePath() (string, error) {
+// settingsPathEnv names a settings.pkl for the settings commands to manage
+// instead of ~/.pkl/settings.pkl.
+const settingsPathEnv = "PKL_SETTINGS_PATH"
+
+// settingsFilePath returns the settings.pkl to manage: override if set, else
+// $PKL_SETTINGS_PATH, else ~/.pkl/settings.pkl.
+func settingsFilePath(override string) (string, error) {
+	if override == "" {
+		override = os.Getenv(settingsPathEnv)
+	}
+	if override != "" {
+		path, err := filepath.Abs(override)
+		if err != nil {
+			return "", fmt.Errorf("resolving settings path: %w", err)
+		}
+		return path, nil
+	}
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return "", fmt.Errorf("getting home directory: %w", err)
@@ -73,6 +96,25 @@ func settingsFilePath() (string, error) {
 	return filepath.Join(homeDir, ".pkl", "settings.pkl"), nil
 }
 
+// rewritesImportRe matches the import line settings install adds, whatever
+// location it imports rewrites.pkl from.
+var rewritesImportRe = regexp.MustCompile(`(?m)^import "[^"]*" as pklProxy$`)
+
+// rewritesImport returns the line importing rewritesFile into settingsFile.
+// Next to the rewrites directory (the usual ~/.pkl/settings.pkl) the import is
+// relative; anywhere else it is an absolute file URI.
+func rewritesImport(settingsFile, rewritesFile string) string {
+	uri := "pkl-proxy/rewrites.pkl"
+	if filepath.Dir(settingsFile) != filepath.Dir(filepath.Dir(rewritesFile)) {
+		path := filepath.ToSlash(rewritesFile)
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path // Windows drive paths: file:///C:/...
+		}
+		uri = (&url.URL{Scheme: "file", Path: path}).String()
+	}
+	return fmt.Sprintf("import %q as pklProxy", uri)
+}
+
 // normalizePath strips URL prefixes to extract the github user[/repo] path.
 // e.g. "https://pkg.pkl-lang.org/github.com/joesmith/repo" -> "joesmith/repo"
 func normalizePath(input string) (string, error) {
@@ -121,14 +163,101 @@ func readPaths(filePath string) ([]string, error) {
 	return paths, scanner.Err()
 }
 
+// readListenAddress reads the fallback listen address baked into rewrites.pkl.
+func readListenAddress(filePath string) (string, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	for line := range strings.Lines(string(data)) {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "local listenAddress") {
+			continue
+		}
+		_, quoted, ok := strings.Cut(line, "?? ")
+		if !ok {
+			break
+		}
+		return strings.Trim(quoted, `"`), nil
+	}
+	return "", fmt.Errorf("no listen address found in %s", filePath)
+}
+
+// rewriteTarget is where rewrites.pkl points pkl: the proxy's host:port, with an
+// https:// scheme when the proxy serves TLS.
+func rewriteTarget(cfg *appconfig.AppConfig) string {
+	if proxy.TLSEnabled(cfg) {
+		return "https://" + proxy.ClientAddress(cfg.ListenAddress)
+	}
+	return proxy.ClientAddress(cfg.ListenAddress)
+}
+
+// targetURL is the base URL pkl sends rewritten requests to for a rewrite target.
+func targetURL(target string) string {
+	scheme, address := proxy.CutScheme(target)
+	return scheme + "://" + proxy.ClientAddress(address)
+}
+
+// warnListenMismatch warns when the address rewrites.pkl sends pkl to differs
+// from the address the proxy is configured to listen on, in which case pkl
+// would silently bypass the proxy.
+func warnListenMismatch(rewritesFile string) {
+	configDir, err := findConfigDir()
+	if err != nil {
+		return
+	}
+	config, err := proxy.LoadConfig(configDir)
+	if err != nil {
+		return
+	}
+	rewriteAddress, err := readListenAddress(rewritesFile)
+	if err != nil {
+		return
+	}
+
+	want := rewriteTarget(config)
+	if targetURL(rewriteAddress) != targetURL(want) {
+		fmt.Printf("Warning: %s rewrites to %q but the proxy listens on %q (listenAddress in %s).\n",
+			rewritesFile, rewriteAddress, want, configDir)
+		fmt.Println("Fix listenAddress, or uninstall and re-install a path to regenerate the rewrites.")
+	}
+}
+
+// warnRemoteTarget warns when rewrites.pkl sends pkl to a non-loopback address
+// and the proxy has no proxyAuthToken, in which case anyone who can reach that
+// address can download private assets with the app's credentials.
+func warnRemoteTarget(rewritesFile string) {
+	target, err := readListenAddress(rewritesFile)
+	if err != nil {
+		return
+	}
+	if _, address := proxy.CutScheme(target); isLoopbackAddress(address) {
+		return
+	}
+	if configDir, err := findConfigDir(); err == nil {
+		if config, err := proxy.LoadConfig(configDir); err == nil && config.ProxyAuthToken != nil {
+			return
+		}
+	}
+	fmt.Printf("Warning: rewrites point pkl at %s, which is not a loopback address.\n", target)
+	fmt.Println("Without proxyAuthToken the proxy does not authenticate clients, so anyone who can reach it can download your private assets.")
+}
+
 func writeRewritesPkl(filePath string, listenAddress string, paths []string) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return fmt.Errorf("creating rewrites directory: %w", err)
+	}
 	f, err := os.Create(filePath)
 	if err != nil {
 		return fmt.Errorf("creating rewrites file: %w", err)
 	}
 	defer f.Close()
 
-	return rewritesTmpl.Execute(f, struct {
+	return renderRewritesPkl(f, listenAddress, paths)
+}
+
+func renderRewritesPkl(w io.Writer, listenAddress string, paths []string) error {
+	return rewritesTmpl.Execute(w, struct {
 		ListenAddress string
 		Paths         []string
 	}{
@@ -147,7 +276,69 @@ func pklEval(filePath string) error {
 	return nil
 }
 
-func cmdInstall(input string) error {
+// previewRewrites prints the lines of filePath that writing listenAddress and
+// paths would change, as a diff, instead of writing it.
+func previewRewrites(filePath, listenAddress string, paths []string) error {
+	old, err := os.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading rewrites file: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := renderRewritesPkl(&buf, listenAddress, paths); err != nil {
+		return err
+	}
+	fmt.Printf("--- %s\n+++ %s (dry run)\n", filePath, filePath)
+	printLineDiff(os.Stdout, strings.SplitAfter(string(old), "\n"), strings.SplitAfter(buf.String(), "\n"))
+	return nil
+}
+
+// printLineDiff writes every line of a and b prefixed with " " if both have it,
+// "-" if only a does, and "+" if only b does. It diffs by longest common
+// subsequence, which is plenty for files the size of rewrites.pkl.
+func printLineDiff(w io.Writer, a, b []string) {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	line := func(prefix, s string) {
+		if s != "" {
+			fmt.Fprint(w, prefix+strings.TrimSuffix(s, "\n")+"\n")
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			line(" ", a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			line("-", a[i])
+			i++
+		default:
+			line("+", b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		line("-", a[i])
+	}
+	for ; j < len(b); j++ {
+		line("+", b[j])
+	}
+}
+
+func cmdInstall(input string, dryRun bool) error {
 	path, err := normalizePath(input)
 	if err != nil {
 		return err
@@ -157,7 +348,7 @@ func cmdInstall(input string) error {
 	if err != nil {
 		return err
 	}
-	config, err := loadConfig(configDir)
+	config, err := proxy.LoadConfig(configDir)
 	if err != nil {
 		return err
 	}
@@ -186,7 +377,10 @@ func cmdInstall(input string) error {
 	}
 
 	paths := append(existing, path)
-	if err := writeRewritesPkl(filePath, config.ListenAddress, paths); err != nil {
+	if dryRun {
+		return previewRewrites(filePath, rewriteTarget(config), paths)
+	}
+	if err := writeRewritesPkl(filePath, rewriteTarget(config), paths); err != nil {
 		return err
 	}
 
@@ -198,7 +392,7 @@ func cmdInstall(input string) error {
 	return nil
 }
 
-func cmdUninstall(input string) error {
+func cmdUninstall(input string, dryRun bool) error {
 	path, err := normalizePath(input)
 	if err != nil {
 		return err
@@ -208,7 +402,7 @@ func cmdUninstall(input string) error {
 	if err != nil {
 		return err
 	}
-	config, err := loadConfig(configDir)
+	config, err := proxy.LoadConfig(configDir)
 	if err != nil {
 		return err
 	}
@@ -238,7 +432,10 @@ func cmdUninstall(input string) error {
 		return nil
 	}
 
-	if err := writeRewritesPkl(filePath, config.ListenAddress, paths); err != nil {
+	if dryRun {
+		return previewRewrites(filePath, rewriteTarget(config), paths)
+	}
+	if err := writeRewritesPkl(filePath, rewriteTarget(config), paths); err != nil {
 		return err
 	}
 
@@ -247,8 +444,8 @@ func cmdUninstall(input string) error {
 	return nil
 }
 
-func cmdSettingsInstall() error {
-	filePath, err := settingsFilePath()
+func cmdSettingsInstall(settingsPath string) error {
+	filePath, err := settingsFilePath(settingsPath)
 	if err != nil {
 		return err
 	}
@@ -263,12 +460,16 @@ func cmdSettingsInstall() error {
 		return fmt.Errorf("no rewrites file found at %s\nRun 'pkl-proxy install <path>' first", rewritesFile)
 	}
 
+	warnListenMismatch(rewritesFile)
+	warnRemoteTarget(rewritesFile)
+
 	// If settings.pkl doesn't exist, create it fresh
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
 			return fmt.Errorf("creating pkl directory: %w", err)
 		}
-		if err := os.WriteFile(filePath, []byte(settingsPklTemplate), 0644); err != nil {
+		content := fmt.Sprintf(settingsPklTemplate, rewritesImport(filePath, rewritesFile))
+		if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
 			return fmt.Errorf("writing settings.pkl: %w", err)
 		}
 		// Validate with pkl
@@ -298,10 +499,10 @@ func cmdSettingsInstall() error {
 	}
 
 	// Add import and for-loop
-	importLine := `import "pkl-proxy/rewrites.pkl" as pklProxy`
+	importLine := rewritesImport(filePath, rewritesFile)
 	forBlock := "    for (key, value in pklProxy.rewrites) {\n      [key] = value\n    }"
 
-	if !strings.Contains(content, importLine) {
+	if !rewritesImportRe.MatchString(content) {
 		content = strings.Replace(content,
 			`amends "pkl:settings"`,
 			"amends \"pkl:settings\"\n\n"+importLine,
@@ -337,8 +538,44 @@ func cmdSettingsInstall() error {
 	return warnConflicts(filePath, content)
 }
 
-func cmdSettingsUninstall() error {
-	filePath, err := settingsFilePath()
+// cmdSettingsPrint prints the rewrites module and settings.pkl block that
+// install and settings install would write, without touching either file.
+func cmdSettingsPrint(settingsPath string) error {
+	configDir, err := findConfigDir()
+	if err != nil {
+		return err
+	}
+	config, err := proxy.LoadConfig(configDir)
+	if err != nil {
+		return err
+	}
+
+	rewritesFile, err := rewritesFilePath()
+	if err != nil {
+		return err
+	}
+	settingsFile, err := settingsFilePath(settingsPath)
+	if err != nil {
+		return err
+	}
+
+	paths, err := readPaths(rewritesFile)
+	if err != nil {
+		return fmt.Errorf("reading existing rewrites: %w", err)
+	}
+
+	fmt.Printf("// %s\n", rewritesFile)
+	if err := renderRewritesPkl(os.Stdout, rewriteTarget(config), paths); err != nil {
+		return err
+	}
+	fmt.Println()
+	fmt.Printf("// %s\n", settingsFile)
+	fmt.Printf(settingsPklTemplate, rewritesImport(settingsFile, rewritesFile))
+	return nil
+}
+
+func cmdSettingsUninstall(settingsPath string) error {
+	filePath, err := settingsFilePath(settingsPath)
 	if err != nil {
 		return err
 	}
@@ -358,8 +595,14 @@ func cmdSettingsUninstall() error {
 		return nil
 	}
 
-	// Remove the import line
-	content = strings.Replace(content, "\nimport \"pkl-proxy/rewrites.pkl\" as pklProxy\n", "\n", 1)
+	// Remove the import line, with the line break after it
+	if loc := rewritesImportRe.FindStringIndex(content); loc != nil {
+		end := loc[1]
+		if end < len(content) && content[end] == '\n' {
+			end++
+		}
+		content = content[:loc[0]] + content[end:]
+	}
 
 	// Remove the for-loop block
 	lines := strings.Split(content, "\n")
@@ -396,9 +639,96 @@ func cmdSettingsUninstall() error {
 	return nil
 }
 
-// settingsHasProxy checks if ~/.pkl/settings.pkl has pkl-proxy rewrites wired in.
+// cmdSettingsTest reports the rewrite rules pkl will pick up from pkl-proxy,
+// whether rewrites.pkl targets the address the proxy listens on, and whether a
+// proxy answers there. Only the managed import in settings.pkl is inspected;
+// other user content is ignored.
+func cmdSettingsTest(settingsPath string) error {
+	settingsFile, err := settingsFilePath(settingsPath)
+	if err != nil {
+		return err
+	}
+	rewritesFile, err := rewritesFilePath()
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(settingsFile)
+	if err != nil {
+		return fmt.Errorf("reading settings.pkl: %w", err)
+	}
+	content := string(data)
+	if !rewritesImportRe.MatchString(content) || !strings.Contains(content, "pklProxy.rewrites") {
+		return fmt.Errorf("%s does not include the pkl-proxy rewrites; run 'pkl-proxy settings install'", settingsFile)
+	}
+	fmt.Printf("%s includes the pkl-proxy rewrites\n", settingsFile)
+
+	paths, err := readPaths(rewritesFile)
+	if err != nil {
+		return fmt.Errorf("reading existing rewrites: %w", err)
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("%s has no paths; run 'pkl-proxy install <path>'", rewritesFile)
+	}
+
+	fileTarget, err := readListenAddress(rewritesFile)
+	if err != nil {
+		return err
+	}
+
+	configDir, err := findConfigDir()
+	if err != nil {
+		return err
+	}
+	config, err := proxy.LoadConfig(configDir)
+	if err != nil {
+		return err
+	}
+	listen := targetURL(rewriteTarget(config))
+
+	// rewrites.pkl prefers the env var over its baked-in fallback, as pkl will.
+	// LoadConfig applies the same env var, so the two can only disagree when
+	// it is unset.
+	target := fileTarget
+	match := true
+	if env := os.Getenv(proxy.ListenAddressEnv); env != "" {
+		target = env
+		fmt.Printf("%s=%s overrides %s in %s\n", proxy.ListenAddressEnv, env, fileTarget, rewritesFile)
+	} else {
+		match = targetURL(fileTarget) == listen
+	}
+
+	fmt.Printf("Proxy listens on %s\n", listen)
+	fmt.Println("Rewrite rules:")
+	for _, path := range paths {
+		for _, from := range []string{"https://github.com/", "https://pkg.pkl-lang.org/github.com/"} {
+			status := "ok"
+			if !match {
+				status = "MISMATCH"
+			}
+			fmt.Printf("  %s%s/ -> %s/%s/  [%s]\n", from, path, targetURL(target), path, status)
+		}
+	}
+
+	if !match {
+		return fmt.Errorf("rewrites target %s but the proxy listens on %s", fileTarget, listen)
+	}
+
+	resp, err := healthClient().Get(targetURL(target) + "/healthz")
+	if err != nil {
+		return fmt.Errorf("no proxy reachable on %s: %w", targetURL(target), err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy on %s is not ready: %s", targetURL(target), resp.Status)
+	}
+	fmt.Printf("Proxy on %s is ready\n", targetURL(target))
+	return nil
+}
+
+// settingsHasProxy checks if settings.pkl has pkl-proxy rewrites wired in.
 func settingsHasProxy() bool {
-	filePath, err := settingsFilePath()
+	filePath, err := settingsFilePath("")
 	if err != nil {
 		return false
 	}
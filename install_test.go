@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bmurray/pkl-proxy/gen/appconfig"
+)
+
+// captureStdout returns what fn prints to stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	fn()
+	w.Close()
+	return <-out
+}
+
+func TestWarnRemoteTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		config   string
+		wantWarn bool
+	}{
+		{"loopback", "localhost:9443", `{"token":"t"}`, false},
+		{"loopback IPv6", "[::1]:9443", `{"token":"t"}`, false},
+		{"remote bind", "10.0.0.5:9443", `{"token":"t","listenAddress":"10.0.0.5:9443"}`, true},
+		{"remote bind over TLS", "https://proxy.internal:9443", `{"token":"t","listenAddress":"proxy.internal:9443"}`, true},
+		{"remote bind with proxyAuthToken", "10.0.0.5:9443", `{"token":"t","listenAddress":"10.0.0.5:9443","proxyAuthToken":"s"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			prev := configDirFlag
+			t.Cleanup(func() { configDirFlag = prev })
+			configDirFlag = dir
+
+			rewritesFile := filepath.Join(dir, "pkl-proxy", "rewrites.pkl")
+			if err := writeRewritesPkl(rewritesFile, tt.target, []string{"acme/tool"}); err != nil {
+				t.Fatal(err)
+			}
+			out := captureStdout(t, func() { warnRemoteTarget(rewritesFile) })
+			if warned := strings.Contains(out, "not a loopback address"); warned != tt.wantWarn {
+				t.Errorf("warned = %v, want %v; output:\n%s", warned, tt.wantWarn, out)
+			}
+		})
+	}
+}
+
+func TestRewriteTargetFollowsListenAddress(t *testing.T) {
+	cert, key := "cert.pem", "key.pem"
+	tests := []struct {
+		config appconfig.AppConfig
+		want   string
+	}{
+		{appconfig.AppConfig{ListenAddress: "localhost:9443"}, "localhost:9443"},
+		{appconfig.AppConfig{ListenAddress: "10.0.0.5:8080"}, "10.0.0.5:8080"},
+		{appconfig.AppConfig{ListenAddress: ":8080"}, "localhost:8080"},
+		{appconfig.AppConfig{ListenAddress: "0.0.0.0:8080"}, "localhost:8080"},
+		{appconfig.AppConfig{ListenAddress: "10.0.0.5:8080", TlsCertFile: &cert, TlsKeyFile: &key}, "https://10.0.0.5:8080"},
+	}
+	for _, tt := range tests {
+		if got := rewriteTarget(&tt.config); got != tt.want {
+			t.Errorf("rewriteTarget(listenAddress %q) = %q, want %q", tt.config.ListenAddress, got, tt.want)
+		}
+	}
+}
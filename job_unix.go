@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "os/exec"
+
+// containChild is a no-op on Unix, where the command shares pkl-proxy's process
+// group and so gets the terminal's signals along with it.
+func containChild(cmd *exec.Cmd) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestContainChildKillsCommandOnRelease(t *testing.T) {
+	cmd := helperCommand("sleep")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	release, err := containChild(cmd)
+	if err != nil {
+		cmd.Process.Kill()
+		t.Fatalf("containChild: %v", err)
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	release()
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("command still running after its job object was closed")
+	}
+}
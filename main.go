@@ -2,59 +2,137 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
+
+	"github.com/bmurray/pkl-proxy/gen/appconfig"
+	"github.com/bmurray/pkl-proxy/pkg/proxy"
 )
 
 func main() {
-	if len(os.Args) < 2 {
+	proxy.Version = version
+	fs := flag.NewFlagSet("pkl-proxy", flag.ExitOnError)
+	fs.Usage = usage
+	fs.BoolVar(&proxy.TraceCurl, "trace-curl", false, "")
+	fs.StringVar(&configDirFlag, "config-dir", "", "")
+	fs.StringVar(&listenFlag, "listen", "", "")
+	fs.Func("pkl-property", "", func(kv string) error {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("expected key=value, got %q", kv)
+		}
+		proxy.PklProperties[k] = v
+		return nil
+	})
+	fs.Parse(os.Args[1:])
+	args := fs.Args()
+
+	if len(args) < 1 {
 		usage()
 	}
 
-	switch os.Args[1] {
+	switch args[0] {
 	case "install":
-		if len(os.Args) < 3 {
-			fmt.Println("Usage: pkl-proxy install <github-path>")
+		installFlags := flag.NewFlagSet("install", flag.ExitOnError)
+		dryRun := installFlags.Bool("dry-run", false, "print the change to rewrites.pkl without writing it")
+		installFlags.Parse(args[1:])
+		if installFlags.NArg() < 1 {
+			fmt.Println("Usage: pkl-proxy install [--dry-run] <github-path>")
 			os.Exit(1)
 		}
-		if err := cmdInstall(os.Args[2]); err != nil {
+		if err := cmdInstall(installFlags.Arg(0), *dryRun); err != nil {
 			fmt.Println("Error:", err)
 			os.Exit(1)
 		}
 	case "uninstall":
-		if len(os.Args) < 3 {
-			fmt.Println("Usage: pkl-proxy uninstall <github-path>")
+		uninstallFlags := flag.NewFlagSet("uninstall", flag.ExitOnError)
+		dryRun := uninstallFlags.Bool("dry-run", false, "print the change to rewrites.pkl without writing it")
+		uninstallFlags.Parse(args[1:])
+		if uninstallFlags.NArg() < 1 {
+			fmt.Println("Usage: pkl-proxy uninstall [--dry-run] <github-path>")
 			os.Exit(1)
 		}
-		if err := cmdUninstall(os.Args[2]); err != nil {
+		if err := cmdUninstall(uninstallFlags.Arg(0), *dryRun); err != nil {
 			fmt.Println("Error:", err)
 			os.Exit(1)
 		}
 	case "settings":
-		if len(os.Args) < 3 {
-			fmt.Println("Usage: pkl-proxy settings <install|uninstall>")
+		if len(args) < 2 {
+			fmt.Println("Usage: pkl-proxy settings <install|uninstall|print|test> [settings.pkl]")
 			os.Exit(1)
 		}
-		switch os.Args[2] {
+		// An explicit settings.pkl path wins over PKL_SETTINGS_PATH
+		var settingsPath string
+		if len(args) > 2 {
+			settingsPath = args[2]
+		}
+		switch args[1] {
 		case "install":
-			if err := cmdSettingsInstall(); err != nil {
+			if err := cmdSettingsInstall(settingsPath); err != nil {
 				fmt.Println("Error:", err)
 				os.Exit(1)
 			}
 		case "uninstall":
-			if err := cmdSettingsUninstall(); err != nil {
+			if err := cmdSettingsUninstall(settingsPath); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+		case "print":
+			if err := cmdSettingsPrint(settingsPath); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+		case "test":
+			if err := cmdSettingsTest(settingsPath); err != nil {
 				fmt.Println("Error:", err)
 				os.Exit(1)
 			}
 		default:
-			fmt.Println("Usage: pkl-proxy settings <install|uninstall>")
+			fmt.Println("Usage: pkl-proxy settings <install|uninstall|print|test> [settings.pkl]")
+			os.Exit(1)
+		}
+	case "version":
+		cmdVersion()
+	case "validate-config":
+		if err := cmdValidateConfig(); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	case "status":
+		if err := cmdStatus(); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	case "list-installations":
+		listFlags := flag.NewFlagSet("list-installations", flag.ExitOnError)
+		asJSON := listFlags.Bool("json", false, "print installations as JSON")
+		listFlags.Parse(args[1:])
+		if err := cmdListInstallations(*asJSON); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	case "test":
+		if len(args) < 2 {
+			fmt.Println("Usage: pkl-proxy test <owner/repo>")
+			os.Exit(1)
+		}
+		if err := cmdTest(args[1]); err != nil {
+			fmt.Println("Error:", err)
 			os.Exit(1)
 		}
 	case "daemon":
@@ -63,17 +141,26 @@ func main() {
 			os.Exit(1)
 		}
 	case "run":
-		if len(os.Args) < 3 {
-			fmt.Println("Usage: pkl-proxy run <cmd> [args...]")
+		runFlags := flag.NewFlagSet("run", flag.ExitOnError)
+		var opts runOptions
+		runFlags.DurationVar(&opts.linger, "linger", 0, "keep serving this long after the command exits")
+		runFlags.BoolVar(&opts.oneshot, "oneshot", false, "serve a single asset, then refuse further requests")
+		runFlags.StringVar(&listenFlag, "listen", listenFlag, "listen on this address instead of listenAddress")
+		// Parsing stops at the first non-flag, so flags after the command (as in
+		// "run pkl eval --listen x") are the command's own. A command that itself
+		// starts with "-" goes after "--".
+		runFlags.Parse(args[1:])
+		if runFlags.NArg() < 1 {
+			fmt.Println("Usage: pkl-proxy run [--linger <duration>] [--oneshot] [--listen <address>] [--] <cmd> [args...]")
 			os.Exit(1)
 		}
-		if err := cmdRun(os.Args[2:]); err != nil {
+		if err := cmdRun(runFlags.Args(), opts); err != nil {
 			fmt.Println("Error:", err)
 			os.Exit(1)
 		}
 	default:
 		// Treat as implicit "run" for backwards compatibility
-		if err := cmdRun(os.Args[1:]); err != nil {
+		if err := cmdRun(args, runOptions{}); err != nil {
 			fmt.Println("Error:", err)
 			os.Exit(1)
 		}
@@ -81,68 +168,509 @@ func main() {
 }
 
 func usage() {
-	fmt.Println("Usage: pkl-proxy <command> [args...]")
+	fmt.Println("Usage: pkl-proxy [flags] <command> [args...]")
 	fmt.Println("Commands:")
-	fmt.Println("  install <path>      Add a GitHub path to proxy rewrites")
-	fmt.Println("  uninstall <path>    Remove a GitHub path from proxy rewrites")
-	fmt.Println("  settings install    Add pkl-proxy rewrites to ~/.pkl/settings.pkl")
-	fmt.Println("  settings uninstall  Remove pkl-proxy rewrites from ~/.pkl/settings.pkl")
+	fmt.Println("  install [--dry-run] <path>")
+	fmt.Println("                      Add a GitHub path to proxy rewrites, or print the change")
+	fmt.Println("  uninstall [--dry-run] <path>")
+	fmt.Println("                      Remove a GitHub path from proxy rewrites, or print the change")
+	fmt.Println("  settings install [file]")
+	fmt.Println("                      Add pkl-proxy rewrites to file, $PKL_SETTINGS_PATH or ~/.pkl/settings.pkl")
+	fmt.Println("  settings uninstall [file]")
+	fmt.Println("                      Remove pkl-proxy rewrites from that settings.pkl")
+	fmt.Println("  settings print      Print the rewrites and settings.pkl block without writing them")
+	fmt.Println("  settings test       Show the rewrite rules pkl will apply and check a proxy answers there")
+	fmt.Println("  validate-config     Check the config and private key without starting the proxy")
+	fmt.Println("  version             Print the version, commit and build date")
+	fmt.Println("  status              Report whether a proxy is running on the configured address")
+	fmt.Println("  list-installations [--json]")
+	fmt.Println("                      List the accounts the GitHub App is installed on")
+	fmt.Println("  test <owner/repo>   Check the config can authenticate for a repo and read its latest release")
 	fmt.Println("  daemon              Start proxy in daemon mode")
-	fmt.Println("  run <cmd> [args]    Start proxy and run a command")
+	fmt.Println("  run [--linger d] [--oneshot] [--listen addr] <cmd> [args]")
+	fmt.Println("                      Start proxy and run a command, optionally serving for d after it exits")
+	fmt.Println("                      or serving a single asset only")
+	fmt.Println("Flags:")
+	fmt.Println("  --config-dir dir    Use this config directory instead of searching for one")
+	fmt.Println("  --listen addr       Listen on addr instead of listenAddress (run and daemon)")
+	fmt.Println("  --trace-curl        Log each upstream GitHub request as a curl command")
+	fmt.Println("  --pkl-property k=v  Pass an external property to config.pkl (repeatable)")
 	os.Exit(1)
 }
 
-// startProxy sets up config, auth, and starts the HTTP proxy server.
-// Returns the server and the resolved listen address for the env var.
-func startProxy() (*http.Server, string, error) {
+// proxyServer is a running proxy along with the state commands need to manage it.
+type proxyServer struct {
+	server     *http.Server
+	proxy      *proxy.GithubPrivateReleaseProxy
+	tokens     *proxy.TokenManager
+	live       *liveProxy         // serves with proxy, swapped on reload
+	stop       context.CancelFunc // cancels every request's context and closes the server
+	config     *appconfig.AppConfig
+	started    *appconfig.AppConfig // config at startup, which restartSettings keep
+	configDir  string
+	logLevel   slog.Level    // used when the config doesn't set logLevel
+	listenAddr string        // resolved address for the PKL_PROXY_LISTEN_ADDRESS env var
+	status     *statusSocket // nil unless statusSocket is configured
+}
+
+// liveProxy serves each request with the proxy most recently stored in it, so
+// a reload can swap in a new one while the server keeps running. Requests
+// already underway finish with the proxy they started on.
+type liveProxy struct {
+	atomic.Pointer[proxy.GithubPrivateReleaseProxy]
+}
+
+func (l *liveProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.Load().ServeHTTP(w, r)
+}
+
+// newUpstreamClient builds the client the proxy talks to GitHub with.
+var newUpstreamClient = proxy.NewUpstreamClient
+
+// startProxy sets up config, auth, and starts the HTTP proxy server. Logging
+// uses logLevel from config, or defaultLevel when it is unset. Every request's
+// context derives from ctx: cancelling it aborts the upstream requests in flight
+// and closes the server.
+func startProxy(ctx context.Context, defaultLevel slog.Level) (*proxyServer, error) {
 	configDir, err := findConfigDir()
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
 
-	config, err := loadConfig(configDir)
+	config, err := proxy.LoadConfig(configDir)
 	if err != nil {
-		return nil, "", err
+		return nil, err
+	}
+	if listenFlag != "" {
+		if config.ListenAddress, err = proxy.NormalizeListenAddress(listenFlag); err != nil {
+			return nil, fmt.Errorf("--listen: %w", err)
+		}
 	}
 
-	privateKeyPath := config.PrivateKey
-	if !filepath.IsAbs(privateKeyPath) {
-		privateKeyPath = filepath.Join(configDir, privateKeyPath)
+	configureLogging(config, defaultLevel)
+
+	var status *statusSocket
+	if config.StatusSocket != nil {
+		if status, err = newStatusSocket(*config.StatusSocket); err != nil {
+			return nil, err
+		}
 	}
-	privateKey, err := os.ReadFile(privateKeyPath)
-	if err != nil {
-		return nil, "", fmt.Errorf("reading private key file: %w", err)
+	status.emit("starting", "", "")
+
+	var privateKey []byte
+	if proxy.UsesApp(config) {
+		if privateKey, err = proxy.ReadPrivateKey(configDir, config); err != nil {
+			status.Close()
+			return nil, err
+		}
 	}
 
-	tm, err := NewTokenManager(config, privateKey)
+	tm, err := proxy.NewTokenManager(config, configDir, privateKey, newUpstreamClient(config))
 	if err != nil {
-		return nil, "", err
+		status.Close()
+		return nil, err
 	}
 
-	han := NewGithubPrivateReleaseProxy(tm)
+	han := proxy.NewGithubPrivateReleaseProxy(tm, config)
+	live := &liveProxy{}
+	live.Store(han)
 
 	svr := &http.Server{
 		Addr:    config.ListenAddress,
-		Handler: han,
+		Handler: live,
 	}
 
-	listenAddr := config.ListenAddress
-	if strings.HasPrefix(listenAddr, ":") {
-		listenAddr = "localhost" + listenAddr
+	var serve func(net.Listener) error = svr.Serve
+	scheme := ""
+	if proxy.TLSEnabled(config) {
+		cert, err := tls.LoadX509KeyPair(*config.TlsCertFile, *config.TlsKeyFile)
+		if err != nil {
+			status.Close()
+			return nil, fmt.Errorf("loading TLS certificate: %w", err)
+		}
+		svr.TLSConfig, err = proxy.ServerTLSConfig(config)
+		if err != nil {
+			status.Close()
+			return nil, err
+		}
+		svr.TLSConfig.Certificates = []tls.Certificate{cert}
+		serve = func(ln net.Listener) error { return svr.ServeTLS(ln, "", "") }
+		scheme = "https://"
+	}
+
+	// Prefer a socket handed over by systemd; otherwise bind ourselves.
+	ln, err := systemdListener()
+	if err != nil {
+		status.Close()
+		return nil, err
+	}
+	if ln != nil {
+		fmt.Printf("Using socket-activated listener on %s\n", ln.Addr())
+		if config.LoopbackOnly && !isLoopbackAddress(ln.Addr().String()) {
+			ln.Close()
+			status.Close()
+			return nil, fmt.Errorf("socket-activated listener %s is not a loopback address; set loopbackOnly = false to allow it", ln.Addr())
+		}
+	} else {
+		if config.LoopbackOnly {
+			if err := checkLoopback(config.ListenAddress); err != nil {
+				status.Close()
+				return nil, fmt.Errorf("listenAddress %s: %w; use a localhost address or set loopbackOnly = false", config.ListenAddress, err)
+			}
+		}
+		ln, err = net.Listen("tcp", config.ListenAddress)
+		if err != nil {
+			status.Close()
+			return nil, fmt.Errorf("listening on %s: %w", config.ListenAddress, err)
+		}
 	}
+	// The bound address, not the configured one, so port 0 resolves to the
+	// ephemeral port the kernel picked
+	listenAddr := proxy.ClientAddress(ln.Addr().String())
+
+	ctx, stop := context.WithCancel(ctx)
+	svr.BaseContext = func(net.Listener) context.Context { return ctx }
+	context.AfterFunc(ctx, func() { svr.Close() })
 
 	go func() {
-		fmt.Printf("Starting local HTTP server on %s...\n", config.ListenAddress)
-		if err := svr.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Printf("Starting local HTTP server on %s...\n", ln.Addr())
+		if err := serve(ln); err != nil && err != http.ErrServerClosed {
 			fmt.Println("Error starting HTTP server:", err)
 		}
 	}()
 
-	return svr, listenAddr, nil
+	// Clients need the scheme to know to use TLS; plain HTTP stays a bare host:port
+	listenAddr = scheme + listenAddr
+	status.emit("ready", listenAddr, "")
+	return &proxyServer{
+		server:     svr,
+		proxy:      han,
+		tokens:     tm,
+		live:       live,
+		stop:       stop,
+		config:     config,
+		started:    config,
+		configDir:  configDir,
+		logLevel:   defaultLevel,
+		listenAddr: listenAddr,
+		status:     status,
+	}, nil
+}
+
+// restartSettings are the config fields a reload can't apply, because they
+// need a new listener, new credentials, a rebuilt upstream client, or a
+// background task that only starts with the daemon. A reload that changes them
+// warns and keeps the running values.
+var restartSettings = []struct {
+	name  string
+	value func(cfg *appconfig.AppConfig) any
+}{
+	{"listenAddress", func(cfg *appconfig.AppConfig) any { return cfg.ListenAddress }},
+	{"loopbackOnly", func(cfg *appconfig.AppConfig) any { return cfg.LoopbackOnly }},
+	{"tlsCertFile", func(cfg *appconfig.AppConfig) any { return cfg.TlsCertFile }},
+	{"tlsKeyFile", func(cfg *appconfig.AppConfig) any { return cfg.TlsKeyFile }},
+	{"tlsMinVersion", func(cfg *appconfig.AppConfig) any { return cfg.TlsMinVersion }},
+	{"tlsCipherSuites", func(cfg *appconfig.AppConfig) any { return cfg.TlsCipherSuites }},
+	{"statusSocket", func(cfg *appconfig.AppConfig) any { return cfg.StatusSocket }},
+	{"logFormat", func(cfg *appconfig.AppConfig) any { return cfg.LogFormat }},
+	{"appId", func(cfg *appconfig.AppConfig) any { return cfg.AppId }},
+	{"clientId", func(cfg *appconfig.AppConfig) any { return cfg.ClientId }},
+	{"installationId", func(cfg *appconfig.AppConfig) any { return cfg.InstallationId }},
+	{"privateKey", func(cfg *appconfig.AppConfig) any { return cfg.PrivateKey }},
+	{"privateKeyPem", func(cfg *appconfig.AppConfig) any { return cfg.PrivateKeyPem }},
+	{"apps", func(cfg *appconfig.AppConfig) any { return cfg.Apps }},
+	{"token", func(cfg *appconfig.AppConfig) any { return cfg.Token }},
+	{"ownerTokens", func(cfg *appconfig.AppConfig) any { return cfg.OwnerTokens }},
+	{"upstreamTimeout", func(cfg *appconfig.AppConfig) any { return cfg.UpstreamTimeout }},
+	{"tokenStatsInterval", func(cfg *appconfig.AppConfig) any { return cfg.TokenStatsInterval }},
+	{"monitorInterval", func(cfg *appconfig.AppConfig) any { return cfg.MonitorInterval }},
+	{"maxGoroutines", func(cfg *appconfig.AppConfig) any { return cfg.MaxGoroutines }},
+	{"maxOpenFiles", func(cfg *appconfig.AppConfig) any { return cfg.MaxOpenFiles }},
+	{"maxUpstreamAttempts", func(cfg *appconfig.AppConfig) any { return cfg.MaxUpstreamAttempts }},
+}
+
+// reload re-reads the config and applies it to the running server: log level,
+// request limits, timeouts, caching and asset options take effect for new
+// requests. The token manager is kept, so cached tokens survive; settings in
+// restartSettings only produce a warning. On error the old config stays.
+func (ps *proxyServer) reload() error {
+	config, err := proxy.LoadConfig(ps.configDir)
+	if err != nil {
+		return err
+	}
+	var ignored []string
+	for _, s := range restartSettings {
+		if !reflect.DeepEqual(s.value(ps.started), s.value(config)) {
+			ignored = append(ignored, s.name)
+		}
+	}
+	if len(ignored) > 0 {
+		fmt.Printf("Warning: restart pkl-proxy to apply changes to %s\n", strings.Join(ignored, ", "))
+	}
+
+	configureLogLevel(config, ps.logLevel)
+	next := ps.proxy.Reconfigured(config)
+	ps.live.Store(next)
+	ps.proxy = next
+	ps.config = config
+	return nil
+}
+
+// shutdown stops accepting connections and waits up to shutdownTimeout for
+// in-flight requests, notably long asset downloads, to finish before cutting
+// them off along with their upstream requests.
+func (ps *proxyServer) shutdown() error {
+	defer ps.stop()
+	timeout := proxy.DurationOr(&ps.config.ShutdownTimeout, 5*time.Second)
+	if n := ps.proxy.ActiveStreams(); n > 0 {
+		fmt.Printf("Waiting up to %s for %d download(s) to finish...\n", timeout, n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := ps.server.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		fmt.Printf("Shutdown timed out; interrupting %d download(s)\n", ps.proxy.ActiveStreams())
+		ps.stop()
+	}
+	return err
+}
+
+// logLevel is the level of the default slog handler. Loggers derived from it
+// pick up changes, so a reload can adjust it in place.
+var logLevel slog.LevelVar
+
+// configureLogging installs the default slog handler from logFormat and logLevel.
+func configureLogging(config *appconfig.AppConfig, defaultLevel slog.Level) {
+	configureLogLevel(config, defaultLevel)
+	opts := &slog.HandlerOptions{Level: &logLevel}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if config.LogFormat == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// configureLogLevel sets logLevel from the config, or to defaultLevel when
+// the config doesn't set one.
+func configureLogLevel(config *appconfig.AppConfig, defaultLevel slog.Level) {
+	level := defaultLevel
+	if config.LogLevel != nil {
+		level.UnmarshalText([]byte(*config.LogLevel))
+	}
+	logLevel.Set(level)
+}
+
+// isLoopbackAddress reports whether a host:port address points at this machine only.
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// checkLoopback returns an error unless every address host:port resolves to is
+// a loopback address. An empty or unspecified host listens on all interfaces.
+func checkLoopback(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(host, "localhost") {
+		return nil
+	}
+	if host == "" {
+		return errors.New("an empty host listens on every interface")
+	}
+	addrs := []string{host}
+	if net.ParseIP(host) == nil {
+		if addrs, err = net.LookupHost(host); err != nil {
+			return err
+		}
+	}
+	for _, a := range addrs {
+		if ip := net.ParseIP(a); ip == nil || !ip.IsLoopback() {
+			return fmt.Errorf("%s is not a loopback address", a)
+		}
+	}
+	return nil
+}
+
+func cmdStatus() error {
+	configDir, err := findConfigDir()
+	if err != nil {
+		return err
+	}
+	config, err := proxy.LoadConfig(configDir)
+	if err != nil {
+		return err
+	}
+
+	addr := targetURL(rewriteTarget(config))
+	fmt.Printf("Listen address: %s\n", addr)
+	switch {
+	case config.AppId != nil:
+		fmt.Printf("Auth:           GitHub App, app ID %d\n", *config.AppId)
+	case config.ClientId != nil:
+		fmt.Printf("Auth:           GitHub App, client ID %s\n", *config.ClientId)
+	case config.Token != nil:
+		fmt.Println("Auth:           personal access token")
+	}
+	for _, app := range config.Apps {
+		fmt.Printf("Auth:           GitHub App %s for %s\n", proxy.AppName(app.AppId, app.ClientId), strings.Join(app.Owners, ", "))
+	}
+	if config.InstallationId != nil {
+		fmt.Printf("Installation:   %d (pinned)\n", *config.InstallationId)
+	}
+
+	resp, err := healthClient().Get(addr + "/healthz")
+	if err != nil {
+		fmt.Println("Status:         not running")
+		return fmt.Errorf("no proxy reachable on %s", addr)
+	}
+	defer resp.Body.Close()
+
+	var health struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&health)
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("Status:         running, not ready (%s)\n", health.Error)
+		return nil
+	}
+	fmt.Println("Status:         running, ready")
+	return nil
+}
+
+// healthClient returns the client status checks probe /healthz with. The check
+// only asks whether something answers, so a self-signed certificate is fine.
+func healthClient() *http.Client {
+	return &http.Client{
+		Timeout:   3 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+}
+
+func cmdListInstallations(asJSON bool) error {
+	configDir, err := findConfigDir()
+	if err != nil {
+		return err
+	}
+	config, err := proxy.LoadConfig(configDir)
+	if err != nil {
+		return err
+	}
+	if !proxy.UsesApp(config) {
+		return fmt.Errorf("listing installations needs GitHub App auth (appId or clientId)")
+	}
+	privateKey, err := proxy.ReadPrivateKey(configDir, config)
+	if err != nil {
+		return err
+	}
+	appTS, err := proxy.BuildTokenSource(config, privateKey)
+	if err != nil {
+		return err
+	}
+
+	installations, err := proxy.DiscoverInstallations(proxy.NewUpstreamClient(config), appTS)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		type installation struct {
+			Account             string `json:"account"`
+			ID                  int    `json:"id"`
+			RepositorySelection string `json:"repositorySelection"`
+		}
+		out := make([]installation, 0, len(installations))
+		for _, inst := range installations {
+			out = append(out, installation{inst.Account.Login, inst.ID, inst.RepositorySelection})
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+
+	if len(installations) == 0 {
+		fmt.Println("No installations found; install the GitHub App on an account first")
+		return nil
+	}
+	for _, inst := range installations {
+		fmt.Printf("%-30s  ID %-12d  repositories: %s\n", inst.Account.Login, inst.ID, inst.RepositorySelection)
+	}
+	return nil
+}
+
+// testAssetsShown is how many asset names cmdTest prints.
+const testAssetsShown = 5
+
+// cmdTest checks end to end that the config can authenticate for owner/repo and
+// read its latest release, without starting the proxy.
+func cmdTest(fullName string) error {
+	owner, repo, ok := strings.Cut(fullName, "/")
+	if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+		return fmt.Errorf("expected owner/repo, got %q", fullName)
+	}
+
+	configDir, err := findConfigDir()
+	if err != nil {
+		return err
+	}
+	config, err := proxy.LoadConfig(configDir)
+	if err != nil {
+		return err
+	}
+	configureLogging(config, slog.LevelWarn)
+	var privateKey []byte
+	if proxy.UsesApp(config) {
+		if privateKey, err = proxy.ReadPrivateKey(configDir, config); err != nil {
+			return err
+		}
+	}
+	tm, err := proxy.NewTokenManager(config, configDir, privateKey, proxy.NewUpstreamClient(config))
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	auth, err := tm.DescribeAuth(ctx, owner, repo)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Auth:         %s\n", auth)
+	if _, err := tm.TokenForRepo(ctx, owner, repo); err != nil {
+		return fmt.Errorf("getting a token for %s: %w", fullName, err)
+	}
+	fmt.Println("Token:        ok")
+
+	prox := proxy.NewGithubPrivateReleaseProxy(tm, config)
+	release, err := prox.LatestRelease(ctx, owner, repo)
+	if errors.Is(err, proxy.ErrNotFound) {
+		return fmt.Errorf("%s has no published release, or the token can't see the repo", fullName)
+	}
+	if err != nil {
+		return fmt.Errorf("fetching latest release of %s: %w", fullName, err)
+	}
+	fmt.Printf("Latest:       %s (%d assets)\n", release.TagName, len(release.Assets))
+	for i, asset := range release.Assets {
+		if i == testAssetsShown {
+			fmt.Printf("  ... and %d more\n", len(release.Assets)-testAssetsShown)
+			break
+		}
+		fmt.Printf("  - %s (%d bytes)\n", asset.Name, asset.Size)
+	}
+	return nil
 }
 
 func cmdDaemon() error {
-	svr, _, err := startProxy()
+	ps, err := startProxy(context.Background(), slog.LevelInfo)
 	if err != nil {
 		return err
 	}
@@ -152,35 +680,143 @@ func cmdDaemon() error {
 		go reapChildren()
 	}
 
-	// Wait for shutdown signal
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	defer stopMonitor()
+	if ps.config.MonitorInterval != nil {
+		mon := &resourceMonitor{
+			interval: proxy.DurationOr(ps.config.MonitorInterval, time.Minute),
+			log:      slog.Default().With("component", "resourceMonitor"),
+		}
+		if ps.config.MaxGoroutines != nil {
+			mon.maxGoroutines = *ps.config.MaxGoroutines
+		}
+		if ps.config.MaxOpenFiles != nil {
+			mon.maxOpenFiles = *ps.config.MaxOpenFiles
+		}
+		go mon.run(monitorCtx)
+	}
+	if ps.config.TokenStatsInterval != nil {
+		interval := proxy.DurationOr(ps.config.TokenStatsInterval, time.Minute)
+		go reportTokenStats(monitorCtx, ps.tokens, interval, slog.Default().With("component", "TokenManager"))
+	}
+
+	// Reload on SIGHUP until a shutdown signal arrives
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 	s := <-sig
+	for ; s == syscall.SIGHUP; s = <-sig {
+		if err := ps.reload(); err != nil {
+			fmt.Println("Error reloading config, keeping the running one:", err)
+			ps.status.emit("reload failed", "", err.Error())
+			continue
+		}
+		fmt.Println("Reloaded config")
+		ps.status.emit("reloaded", ps.listenAddr, "")
+	}
 	fmt.Printf("\nReceived %s, shutting down...\n", s)
+	ps.status.emit("shutting down", "", s.String())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	return svr.Shutdown(ctx)
+	err = ps.shutdown()
+	ps.status.emit("stopped", "", "")
+	ps.status.Close()
+	return err
 }
 
-func cmdRun(args []string) error {
-	_, listenAddr, err := startProxy()
+// proxyAuthTokenEnv passes proxyAuthToken to commands started by "pkl-proxy run".
+const proxyAuthTokenEnv = "PKL_PROXY_AUTH_TOKEN"
+
+// runOptions are the flags accepted by "pkl-proxy run".
+type runOptions struct {
+	linger  time.Duration // how long to keep serving after the command exits
+	oneshot bool          // serve exactly one asset and report it
+}
+
+func cmdRun(args []string, opts runOptions) error {
+	// Keep the proxy quiet so it doesn't drown out the command's own output
+	ps, err := startProxy(context.Background(), slog.LevelWarn)
 	if err != nil {
 		return err
 	}
 
+	var once *proxy.Oneshot
+	if opts.oneshot {
+		once = ps.proxy.EnableOneshot()
+	}
+
 	execCmd := exec.Command(args[0], args[1:]...)
-	execCmd.Env = append(os.Environ(), "PKL_PROXY_LISTEN_ADDRESS="+listenAddr)
+	execCmd.Env = append(os.Environ(), proxy.ListenAddressEnv+"="+ps.listenAddr)
+	if ps.config.ProxyAuthToken != nil {
+		execCmd.Env = append(execCmd.Env, proxyAuthTokenEnv+"="+*ps.config.ProxyAuthToken)
+	}
 	execCmd.Stdout = os.Stdout
 	execCmd.Stderr = os.Stderr
 
-	if err := execCmd.Run(); err != nil {
-		return fmt.Errorf("executing command: %w", err)
+	// Ctrl+C reaches the command too (same process group on Unix, same console
+	// on Windows). Leave it to the command to exit, then shut down cleanly,
+	// rather than dying and pulling the proxy out from under it.
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+
+	runErr := execCmd.Start()
+	if runErr == nil {
+		release, err := containChild(execCmd)
+		if err != nil {
+			fmt.Println("Warning:", err)
+			release = func() {}
+		}
+		defer release()
+		runErr = execCmd.Wait()
+	}
+	signal.Stop(interrupts)
+
+	// Give background work the command left behind a chance to finish using the proxy
+	if opts.linger > 0 {
+		fmt.Printf("Command exited; proxy serving for another %s\n", opts.linger)
+		time.Sleep(opts.linger)
+	}
+	ps.shutdown()
+
+	if runErr != nil {
+		return fmt.Errorf("executing command: %w", runErr)
+	}
+	if once != nil {
+		path, bytes, ok := once.Served()
+		if !ok {
+			return fmt.Errorf("oneshot: the command exited without fetching anything through the proxy")
+		}
+		fmt.Printf("Oneshot served %s (%d bytes)\n", path, bytes)
 	}
 	return nil
 }
 
+// listenFlag is the --listen flag, accepted before any command and after "run".
+// It overrides listenAddress and PKL_PROXY_LISTEN_ADDRESS for the proxy started
+// by run and daemon.
+var listenFlag string
+
+// configDirFlag is the --config-dir flag; it wins over PKL_PROXY_CONFIG_DIR.
+var configDirFlag string
+
+// configDirEnv names an explicit config directory, skipping discovery.
+const configDirEnv = "PKL_PROXY_CONFIG_DIR"
+
 func findConfigDir() (string, error) {
+	// An explicit directory must exist; falling back elsewhere would load the wrong config
+	dir, source := configDirFlag, "--config-dir"
+	if dir == "" {
+		dir, source = os.Getenv(configDirEnv), configDirEnv
+	}
+	if dir != "" {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return "", fmt.Errorf("config directory %s from %s: %w", dir, source, err)
+		}
+		if !info.IsDir() {
+			return "", fmt.Errorf("config directory %s from %s is not a directory", dir, source)
+		}
+		return dir, nil
+	}
+
 	// Check XDG-compliant config dir first (e.g. ~/.config/pkl-proxy on Linux)
 	if xdgDir, err := os.UserConfigDir(); err == nil {
 		dir := filepath.Join(xdgDir, "pkl-proxy")
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmurray/pkl-proxy/gen/appconfig"
+	"github.com/bmurray/pkl-proxy/pkg/proxy"
+)
+
+// toServer sends every request to plain HTTP on addr, keeping its path, so
+// calls to GitHub reach a test server.
+type toServer struct {
+	addr string
+}
+
+func (t toServer) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = "http"
+	req.URL.Host = t.addr
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// useFakeGitHub points startProxy at a config directory holding config and an
+// upstream client that talks to gh instead of GitHub.
+func useFakeGitHub(t *testing.T, gh *httptest.Server, config string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prevDir, prevClient := configDirFlag, newUpstreamClient
+	t.Cleanup(func() { configDirFlag, newUpstreamClient = prevDir, prevClient })
+	configDirFlag = dir
+	newUpstreamClient = func(*appconfig.AppConfig) *http.Client {
+		return &http.Client{Transport: toServer{addr: gh.Listener.Addr().String()}}
+	}
+}
+
+func TestCancelRootContextStopsProxy(t *testing.T) {
+	started := make(chan struct{})
+	aborted := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/o/r/releases/tags/v1", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"tag_name":"v1","assets":[{"name":"tool","url":"https://api.github.com/repos/o/r/releases/assets/1"}]}`)
+	})
+	mux.HandleFunc("GET /repos/o/r/releases/assets/1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 64<<10)))
+		w.(http.Flusher).Flush()
+		close(started)
+		<-r.Context().Done()
+		close(aborted)
+	})
+	gh := httptest.NewServer(mux)
+	defer gh.Close()
+	useFakeGitHub(t, gh, `{"token":"t","listenAddress":"localhost:0"}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ps, err := startProxy(ctx, slog.LevelWarn)
+	if err != nil {
+		t.Fatalf("startProxy: %v", err)
+	}
+	base := "http://" + ps.listenAddr
+
+	downloaded := make(chan struct{})
+	go func() {
+		defer close(downloaded)
+		resp, err := http.Get(base + "/o/r/v1/tool")
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	// Whether the client sees the download cut off depends on how far the
+	// response got before the server closed, so check the upstream side instead
+	cancel()
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream request was not aborted")
+	}
+	select {
+	case <-downloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("download still running after the root context was cancelled")
+	}
+	waitForServerDown(t, base)
+}
+
+// waitForServerDown polls base until nothing answers there.
+func waitForServerDown(t *testing.T, base string) {
+	t.Helper()
+	client := &http.Client{Timeout: time.Second}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := client.Get(base + "/livez")
+		if err != nil {
+			return
+		}
+		resp.Body.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("server still answers after the root context was cancelled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// helperEnv selects what TestHelperProcess does when the test binary is
+// started as a subprocess: "run" runs a proxy whose command is the "child"
+// mode, "child" waits for an interrupt, and "sleep" just waits to be killed.
+const helperEnv = "PKL_PROXY_TEST_HELPER"
+
+// helperCommand returns a command that runs the test binary in a helper mode.
+func helperCommand(mode string, env ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	cmd.Env = append(append(os.Environ(), helperEnv+"="+mode), env...)
+	return cmd
+}
+
+func TestHelperProcess(t *testing.T) {
+	switch os.Getenv(helperEnv) {
+	case "run":
+		os.Setenv(helperEnv, "child")
+		if err := cmdRun([]string{os.Args[0], "-test.run=^TestHelperProcess$"}, runOptions{}); err != nil {
+			fmt.Println("run:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "child":
+		interrupts := make(chan os.Signal, 1)
+		signal.Notify(interrupts, os.Interrupt)
+		fmt.Println("child ready")
+		select {
+		case <-interrupts:
+		case <-time.After(10 * time.Second):
+			fmt.Println("child timed out waiting for an interrupt")
+			os.Exit(1)
+		}
+		// The proxy must still be serving after the same Ctrl+C
+		resp, err := http.Get("http://" + os.Getenv(proxy.ListenAddressEnv) + "/livez")
+		if err != nil {
+			fmt.Println("child: proxy gone after interrupt:", err)
+			os.Exit(1)
+		}
+		resp.Body.Close()
+		fmt.Println("child got interrupt")
+		os.Exit(0)
+	case "sleep":
+		time.Sleep(time.Minute)
+		os.Exit(0)
+	}
+}
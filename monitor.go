@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"time"
+
+	"github.com/bmurray/pkl-proxy/pkg/proxy"
+)
+
+// resourceMonitor periodically samples goroutine and open file counts and warns
+// when they cross the configured thresholds. It is a leak detector, not a limit.
+type resourceMonitor struct {
+	interval      time.Duration
+	maxGoroutines int // zero disables the warning
+	maxOpenFiles  int // zero disables the warning
+	log           *slog.Logger
+}
+
+func (m *resourceMonitor) run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.sample()
+		}
+	}
+}
+
+func (m *resourceMonitor) sample() {
+	goroutines := runtime.NumGoroutine()
+	openFiles := countOpenFiles()
+	m.log.Debug("Resource usage", "goroutines", goroutines, "openFiles", openFiles)
+
+	if m.maxGoroutines > 0 && goroutines > m.maxGoroutines {
+		m.log.Warn("Goroutine count above threshold", "goroutines", goroutines, "threshold", m.maxGoroutines)
+	}
+	if m.maxOpenFiles > 0 && openFiles > m.maxOpenFiles {
+		m.log.Warn("Open file count above threshold", "openFiles", openFiles, "threshold", m.maxOpenFiles)
+	}
+}
+
+// reportTokenStats logs a summary of tm's cache every interval: how many token
+// sources it holds, and the hit ratio and installation lookups since the last
+// report. Frequent lookups for the same owner suggest pinning installationId.
+func reportTokenStats(ctx context.Context, tm *proxy.TokenManager, interval time.Duration, log *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var last proxy.TokenCacheStats
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stats := tm.CacheStats()
+			hits, misses := stats.Hits-last.Hits, stats.Misses-last.Misses
+			var ratio float64
+			if hits+misses > 0 {
+				ratio = float64(hits) / float64(hits+misses)
+			}
+			log.Info("Token cache stats",
+				"cached", stats.Cached,
+				"hits", hits,
+				"misses", misses,
+				"hitRatio", ratio,
+				"installationLookups", stats.Lookups-last.Lookups,
+				"totalInstallationLookups", stats.Lookups)
+			last = stats
+		}
+	}
+}
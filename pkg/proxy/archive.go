@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// archiveTypes maps the source archive kinds GitHub offers to the content type
+// served for them.
+var archiveTypes = map[string]string{
+	"zipball": "application/zip",
+	"tarball": "application/gzip",
+}
+
+// archiveHandler streams the source of a repo at a ref as a zip or tar.gz,
+// from GitHub's /repos/{owner}/{repo}/{zipball,tarball}/{ref}. GitHub answers
+// with a redirect to a short-lived download URL, which the client follows.
+func (p *GithubPrivateReleaseProxy) archiveHandler(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.PathValue("user")
+		repo := r.PathValue("repo")
+		ref := r.PathValue("ref")
+		p.logFor(r.Context()).Debug("Handling request for source archive", "user", user, "repo", repo, "kind", kind, "ref", ref)
+
+		u, err := url.JoinPath("https://api.github.com/repos/", user, repo, kind, ref)
+		if err != nil {
+			http.Error(w, "Invalid archive path", http.StatusBadRequest)
+			return
+		}
+		ctx := withRepo(r.Context(), user, repo)
+		req, err := newGithubRequest(ctx, u, mediaTypeJSON)
+		if err != nil {
+			p.upstreamError(ctx, w, "Error creating archive request", err)
+			return
+		}
+		if !p.upstream.acquire(ctx) {
+			p.upstreamError(ctx, w, "Error fetching source archive", context.Cause(ctx))
+			return
+		}
+		defer p.upstream.release()
+		resp, err := p.client.Do(req)
+		if err != nil {
+			p.upstreamError(ctx, w, "Error fetching source archive", err)
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusNotFound {
+			http.Error(w, "Repository or ref not found", http.StatusNotFound)
+			return
+		}
+		if err := checkRateLimit(resp); err != nil {
+			p.upstreamError(ctx, w, "Error fetching source archive", err)
+			return
+		}
+		if resp.StatusCode != http.StatusOK {
+			body, detail := readErrorBody(resp)
+			p.logFor(ctx).Debug("GitHub API error response", "url", u, "status", resp.Status, "body", body)
+			p.upstreamError(ctx, w, "Error fetching source archive", fmt.Errorf("GitHub returned %s%s", resp.Status, detail))
+			return
+		}
+
+		if err := p.capBody(resp); err != nil {
+			assetTooLarge(w, resp.ContentLength, p.maxAssetSize)
+			return
+		}
+
+		copyAssetHeaders(w.Header(), resp.Header)
+		w.Header().Set("Content-Type", archiveTypes[kind])
+		p.streams.Add(1)
+		n, err := copyContext(ctx, w, resp.Body)
+		p.streams.Add(-1)
+		if errors.Is(err, errAssetTooLarge) {
+			// Too late for a 413; abort so the truncated archive isn't taken for a whole one
+			p.logFor(ctx).Error("Source archive exceeded maxAssetSize mid-stream", "bytes", n, "maxAssetSize", p.maxAssetSize)
+			panic(http.ErrAbortHandler)
+		}
+		p.metrics.servedBytes(n)
+	}
+}
@@ -0,0 +1,559 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bmurray/pkl-proxy/gen/appconfig"
+	"github.com/jferrl/go-githubauth"
+	"golang.org/x/oauth2"
+	"golang.org/x/sync/singleflight"
+)
+
+// TokenManager lazily discovers and caches installation token sources per owner.
+type TokenManager struct {
+	client *http.Client       // used for every GitHub API call the manager makes
+	app    *githubApp         // default app; nil with token auth or when only apps are configured
+	static oauth2.TokenSource // personal access token for owners without an app
+
+	// apps holds the apps scoped to particular owners, which win over the default.
+	apps map[string]*githubApp // lowercased owner -> app
+
+	// overrides holds per-owner token sources (e.g. a PAT) that bypass the app.
+	overrides map[string]oauth2.TokenSource // lowercased owner -> token source
+
+	mu    sync.RWMutex
+	cache map[string]oauth2.TokenSource // cacheKey -> token source
+
+	// selected holds owners whose installation only covers the repos picked when
+	// the app was installed. Their tokens are cached per repo, each once a
+	// lookup has confirmed the installation includes it.
+	selected map[string]bool // lowercased owner; guarded by mu
+
+	store *installationStore // installation IDs persisted across restarts; nil when disabled
+
+	// lookups collapses concurrent installation lookups for the same owner into one API call.
+	lookups singleflight.Group
+
+	// metrics is set once the proxy is built, possibly while prewarming is
+	// already resolving tokens; it holds nil unless metrics are enabled.
+	metrics atomic.Pointer[proxyMetrics]
+
+	// Counters behind CacheStats, kept whether or not metrics are enabled.
+	hits, misses, installLookups atomic.Int64
+}
+
+// TokenCacheStats is a snapshot of a TokenManager's cache. The counters are
+// totals since the manager was created.
+type TokenCacheStats struct {
+	Cached  int   // cached token sources: one per owner, or per repo for selected installations
+	Hits    int64 // token requests served from the cache
+	Misses  int64 // token requests that had to find an installation first
+	Lookups int64 // installation lookups sent to GitHub
+}
+
+// CacheStats returns the current TokenCacheStats. Owners with a pinned
+// installationId skip the cache, so they count as neither hits nor misses.
+func (tm *TokenManager) CacheStats() TokenCacheStats {
+	tm.mu.RLock()
+	cached := len(tm.cache)
+	tm.mu.RUnlock()
+	return TokenCacheStats{
+		Cached:  cached,
+		Hits:    tm.hits.Load(),
+		Misses:  tm.misses.Load(),
+		Lookups: tm.installLookups.Load(),
+	}
+}
+
+// githubApp is one GitHub App the manager can mint installation tokens with.
+type githubApp struct {
+	name           string             // for diagnostics, e.g. "app 123456"
+	tokens         oauth2.TokenSource // app (JWT) tokens
+	installationId *int               // optional fixed installation ID from config
+}
+
+// NewTokenManager creates a TokenManager from config. Owners listed by one of
+// apps use that app; the rest use the top-level app, or the personal access
+// token when there is none. If an app has an installationId, all of its repos
+// use that installation (no per-repo lookup). Otherwise, installations are
+// auto-discovered per owner on first request, and remembered in configDir when
+// installationCache is on. All GitHub API calls go through client; nil means a
+// default upstream client.
+func NewTokenManager(config *appconfig.AppConfig, configDir string, privateKey []byte, client *http.Client) (*TokenManager, error) {
+	if client == nil {
+		client = upstreamClient(defaultUpstreamTimeout)
+	}
+
+	tm := &TokenManager{
+		client:    client,
+		apps:      make(map[string]*githubApp),
+		overrides: make(map[string]oauth2.TokenSource),
+		cache:     make(map[string]oauth2.TokenSource),
+		selected:  make(map[string]bool),
+	}
+	for owner, token := range config.OwnerTokens {
+		tm.overrides[strings.ToLower(owner)] = githubauth.NewPersonalAccessTokenSource(token)
+	}
+	var apps []*githubApp
+	for _, a := range config.Apps {
+		key, err := ReadKeyFile(configDir, a.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("app for %s: %w", strings.Join(a.Owners, ", "), err)
+		}
+		ts, err := newAppTokenSource(a.AppId, a.ClientId, key)
+		if err != nil {
+			return nil, fmt.Errorf("app for %s: %w", strings.Join(a.Owners, ", "), err)
+		}
+		app := &githubApp{name: AppName(a.AppId, a.ClientId), tokens: ts, installationId: a.InstallationId}
+		for _, owner := range a.Owners {
+			tm.apps[strings.ToLower(owner)] = app
+		}
+		apps = append(apps, app)
+	}
+
+	switch {
+	case UsesApp(config):
+		ts, err := BuildTokenSource(config, privateKey)
+		if err != nil {
+			return nil, err
+		}
+		tm.app = &githubApp{name: AppName(config.AppId, config.ClientId), tokens: ts, installationId: config.InstallationId}
+		apps = append([]*githubApp{tm.app}, apps...)
+	case config.Token != nil:
+		fmt.Println("Using a personal access token for repos without a GitHub App")
+		tm.static = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: *config.Token})
+	case len(apps) == 0:
+		return nil, fmt.Errorf("config must set appId, clientId, apps, or token/tokenFile")
+	}
+
+	if config.InstallationCache {
+		tm.store = loadInstallationStore(filepath.Join(configDir, installationStoreFile))
+	}
+
+	// Print available installations at startup for diagnostics, and make sure a
+	// pinned installationId is one of them: a typo would otherwise only show up
+	// as failed downloads
+	for _, app := range apps {
+		installations, err := DiscoverInstallations(client, app.tokens)
+		if err != nil {
+			fmt.Printf("Warning: could not list installations of %s: %v\n", app.name, err)
+			continue
+		}
+		if len(installations) == 0 {
+			fmt.Printf("Warning: no installations found for %s; install the GitHub App on an account first\n", app.name)
+		} else {
+			fmt.Printf("Available installations of %s:\n", app.name)
+			for _, inst := range installations {
+				fmt.Printf("  - %s (installation ID: %d)\n", inst.Account.Login, inst.ID)
+			}
+		}
+		if app.installationId != nil && !slices.ContainsFunc(installations, func(inst Installation) bool {
+			return inst.ID == *app.installationId
+		}) {
+			return nil, fmt.Errorf("installationId %d is not an installation of %s; pick one of those listed above, or remove it to auto-discover",
+				*app.installationId, app.name)
+		}
+	}
+
+	if len(config.Prewarm) > 0 {
+		go tm.prewarm(config.Prewarm)
+	}
+	return tm, nil
+}
+
+// BuildTokenSource returns the source of app (JWT) tokens for the top-level
+// GitHub App. appId takes precedence over clientId.
+func BuildTokenSource(config *appconfig.AppConfig, privateKey []byte) (oauth2.TokenSource, error) {
+	return newAppTokenSource(config.AppId, config.ClientId, privateKey)
+}
+
+// newAppTokenSource returns the source of app (JWT) tokens for an app identified
+// by appId or, failing that, clientId.
+func newAppTokenSource(appId *int, clientId *string, privateKey []byte) (oauth2.TokenSource, error) {
+	var ts oauth2.TokenSource
+	var err error
+
+	switch {
+	case appId != nil:
+		ts, err = githubauth.NewApplicationTokenSource(int64(*appId), privateKey)
+	case clientId != nil:
+		ts, err = githubauth.NewApplicationTokenSource(*clientId, privateKey)
+	default:
+		return nil, fmt.Errorf("app must set either appId or clientId")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating application token source: %w", err)
+	}
+	return ts, nil
+}
+
+// AppName labels a GitHub App by its app ID or client ID, for messages.
+func AppName(appId *int, clientId *string) string {
+	if appId != nil {
+		return fmt.Sprintf("app %d", *appId)
+	}
+	return "app " + *clientId
+}
+
+// prewarm resolves installations and mints tokens for the given owner/repo
+// entries ahead of the first request. Failures are only logged.
+func (tm *TokenManager) prewarm(repos []string) {
+	for _, fullName := range repos {
+		owner, repo, ok := strings.Cut(fullName, "/")
+		if !ok {
+			fmt.Printf("Warning: prewarm entry %q is not owner/repo\n", fullName)
+			continue
+		}
+		if _, err := tm.TokenForRepo(context.Background(), owner, repo); err != nil {
+			fmt.Printf("Warning: could not prewarm token for %s: %v\n", fullName, err)
+		}
+	}
+}
+
+// ready reports whether the app token source can mint a token. App tokens are
+// signed locally, so this doesn't call the GitHub API.
+func (tm *TokenManager) ready() error {
+	if tm.app != nil {
+		if _, err := tm.app.tokens.Token(); err != nil {
+			return fmt.Errorf("minting app token: %w", err)
+		}
+	}
+	for owner, app := range tm.apps {
+		if _, err := app.tokens.Token(); err != nil {
+			return fmt.Errorf("minting token for %s (%s): %w", app.name, owner, err)
+		}
+	}
+	return nil
+}
+
+// appFor returns the app serving owner: the one scoped to it, else the default.
+func (tm *TokenManager) appFor(owner string) *githubApp {
+	if app, ok := tm.apps[owner]; ok {
+		return app
+	}
+	return tm.app
+}
+
+// DescribeAuth reports how requests for owner/repo are authenticated: which
+// ownerTokens entry, personal access token or app installation is used. An
+// installation that isn't pinned in the config is looked up.
+func (tm *TokenManager) DescribeAuth(ctx context.Context, owner, repo string) (string, error) {
+	key := strings.ToLower(owner)
+	switch app := tm.appFor(key); {
+	case tm.overrides[key] != nil:
+		return fmt.Sprintf("ownerTokens entry for %s", owner), nil
+	case app == nil:
+		return "personal access token", nil
+	case app.installationId != nil:
+		return fmt.Sprintf("%s, installation %d (from config)", app.name, *app.installationId), nil
+	default:
+		inst, err := tm.lookupRepoInstallation(ctx, app, key, strings.ToLower(repo))
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s, installation %d (%s, repositories: %s)", app.name, inst.ID, inst.Account.Login, inst.RepositorySelection), nil
+	}
+}
+
+// TokenForRepo returns a token valid for the given owner/repo. Results are cached
+// per owner since installations are typically per-account. GitHub treats names
+// case-insensitively, so owner and repo are lowercased before caching and lookups.
+// ctx bounds the installation lookup a cache miss needs.
+func (tm *TokenManager) TokenForRepo(ctx context.Context, owner, repo string) (*oauth2.Token, error) {
+	ownerKey := strings.ToLower(owner)
+
+	// Owners with their own token never go through the app
+	if ts, ok := tm.overrides[ownerKey]; ok {
+		return ts.Token()
+	}
+
+	// Without an app there are no installations; the token covers everything
+	app := tm.appFor(ownerKey)
+	if app == nil {
+		if tm.static != nil {
+			return tm.static.Token()
+		}
+		return nil, fmt.Errorf("no GitHub App is configured for %s", owner)
+	}
+
+	// If a fixed installation ID is configured, use it for everything
+	if app.installationId != nil {
+		ts := tm.getOrSetSource(ownerKey, func() oauth2.TokenSource {
+			return tm.installationSource(app, *app.installationId)
+		})
+		return ts.Token()
+	}
+
+	// Check cache (read lock)
+	key := tm.cacheKey(owner, repo)
+	tm.mu.RLock()
+	ts, ok := tm.cache[key]
+	tm.mu.RUnlock()
+	tm.metrics.Load().tokenCacheLookup(ok)
+	if ok {
+		tm.hits.Add(1)
+	} else {
+		tm.misses.Add(1)
+	}
+	evicted := false
+	if ok {
+		token, err := ts.Token()
+		if err == nil || !installationGone(err) {
+			return token, err
+		}
+		// The app was likely uninstalled or reinstalled; forget the old
+		// installation and look it up again.
+		fmt.Printf("Installation for %s is no longer valid (%v); looking it up again\n", owner, err)
+		tm.evict(key, ts)
+		tm.store.remove(key)
+		evicted = true
+	}
+
+	// Cache miss — use the installation remembered from an earlier run, or look
+	// it up for this repo
+	installationID, remembered := tm.store.get(key)
+	if !remembered {
+		repoKey := strings.ToLower(repo)
+		v, err, _ := tm.lookups.Do(key, func() (any, error) {
+			inst, err := tm.lookupRepoInstallation(ctx, app, ownerKey, repoKey)
+			return repoInstallation{repo: repoKey, inst: inst}, err
+		})
+		found := v.(repoInstallation)
+		switch {
+		case err != nil && ctx.Err() == nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)):
+			// Joined a lookup whose own request went away; this one still wants an answer
+			found.inst, err = tm.lookupRepoInstallation(ctx, app, ownerKey, repoKey)
+		case err == nil && found.inst.RepositorySelection == "selected" && found.repo != repoKey:
+			// Joined a lookup for another repo, which says nothing about this one
+			found.inst, err = tm.lookupRepoInstallation(ctx, app, ownerKey, repoKey)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("looking up installation for %s/%s: %w", owner, repo, err)
+		}
+		inst := found.inst
+		installationID = inst.ID
+		if inst.RepositorySelection == "selected" && key == ownerKey {
+			// The owner's other repos may not be covered, so each gets its own lookup
+			tm.mu.Lock()
+			tm.selected[ownerKey] = true
+			tm.mu.Unlock()
+			key = tm.cacheKey(owner, repo)
+		}
+		tm.store.put(key, installationID)
+	}
+
+	ts = tm.getOrSetSource(key, func() oauth2.TokenSource {
+		return tm.installationSource(app, installationID)
+	})
+	token, err := ts.Token()
+	if err != nil && remembered && installationGone(err) {
+		// The remembered installation is gone; forget it and look it up afresh
+		tm.evict(key, ts)
+		tm.store.remove(key)
+		return tm.TokenForRepo(ctx, owner, repo)
+	}
+	if err == nil && evicted {
+		fmt.Printf("Recovered installation %d for %s\n", installationID, owner)
+	}
+	return token, err
+}
+
+// installationSource returns a token source that mints tokens for the given
+// installation. The library wraps it in oauth2.ReuseTokenSource, so a token is
+// reused until shortly before its hour is up and then minted again.
+func (tm *TokenManager) installationSource(app *githubApp, installationID int) oauth2.TokenSource {
+	// WithHTTPClient wraps the client's transport in place, so hand it a copy
+	client := *tm.client
+	return githubauth.NewInstallationTokenSource(int64(installationID), app.tokens,
+		githubauth.WithHTTPClient(&client))
+}
+
+// cacheKey returns the key owner/repo's token source is cached and remembered
+// under: the lowercased owner, or owner/repo if the owner's installation only
+// covers selected repos.
+func (tm *TokenManager) cacheKey(owner, repo string) string {
+	key := strings.ToLower(owner)
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	if tm.selected[key] {
+		return key + "/" + strings.ToLower(repo)
+	}
+	return key
+}
+
+// invalidate drops the cached token source for owner/repo so the next
+// TokenForRepo mints a new token. It reports whether there was one; tokens from
+// config (ownerTokens, token) are never cached and can't be refreshed.
+func (tm *TokenManager) invalidate(owner, repo string) bool {
+	key := tm.cacheKey(owner, repo)
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if _, ok := tm.cache[key]; !ok {
+		return false
+	}
+	delete(tm.cache, key)
+	return true
+}
+
+// evict removes owner's cached token source, unless another goroutine has
+// already replaced it with a fresh one.
+func (tm *TokenManager) evict(owner string, stale oauth2.TokenSource) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if tm.cache[owner] == stale {
+		delete(tm.cache, owner)
+	}
+}
+
+// installationGone reports whether a token mint error means the installation no
+// longer exists or the app lost access to it.
+func installationGone(err error) bool {
+	switch mintStatus(err) {
+	case http.StatusNotFound, http.StatusUnauthorized:
+		return true
+	}
+	return false
+}
+
+// mintStatusPrefix starts the error go-githubauth v1.5.1 returns when the access
+// token endpoint answers with anything but 200 or 201: "GitHub API returned
+// status %d: <body>". The library has no typed error for it, so the status is
+// read back out of the text; TestRevokedInstallationIsLookedUpAgain fails if
+// an upgrade changes the wording.
+const mintStatusPrefix = "GitHub API returned status "
+
+// mintStatus returns the HTTP status of a failed installation token mint, or 0
+// if err didn't come from one.
+func mintStatus(err error) int {
+	rest, ok := strings.CutPrefix(err.Error(), mintStatusPrefix)
+	if !ok {
+		return 0
+	}
+	code, _, _ := strings.Cut(rest, ":")
+	status, err := strconv.Atoi(code)
+	if err != nil {
+		return 0
+	}
+	return status
+}
+
+// getOrSetSource returns the cached token source for owner, or creates one using
+// the provided factory function. Handles the race where two goroutines both miss
+// the read cache concurrently.
+func (tm *TokenManager) getOrSetSource(owner string, factory func() oauth2.TokenSource) oauth2.TokenSource {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if ts, ok := tm.cache[owner]; ok {
+		return ts
+	}
+	ts := factory()
+	tm.cache[owner] = ts
+	return ts
+}
+
+// repoInstallation is the result of looking up the installation covering repo.
+type repoInstallation struct {
+	repo string
+	inst Installation
+}
+
+// lookupRepoInstallation calls GET /repos/{owner}/{repo}/installation to find
+// the installation of app covering a specific repo.
+func (tm *TokenManager) lookupRepoInstallation(ctx context.Context, app *githubApp, owner, repo string) (Installation, error) {
+	var inst Installation
+	token, err := app.tokens.Token()
+	if err != nil {
+		return inst, fmt.Errorf("getting app token: %w", err)
+	}
+
+	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/installation", owner, repo)
+	req, err := newGithubRequest(ctx, url, mediaTypeJSON)
+	if err != nil {
+		return inst, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	tm.installLookups.Add(1)
+
+	resp, err := tm.client.Do(req)
+	if err != nil {
+		return inst, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return inst, fmt.Errorf("%s is not installed on %s/%s, or its installation doesn't include that repo", app.name, owner, repo)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_, detail := readErrorBody(resp)
+		return inst, fmt.Errorf("GitHub API returned %s for %s/%s installation lookup%s", resp.Status, owner, repo, detail)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&inst); err != nil {
+		return inst, fmt.Errorf("decoding installation response: %w", err)
+	}
+
+	fmt.Printf("Discovered installation %d (%s) for %s/%s\n", inst.ID, inst.Account.Login, owner, repo)
+	return inst, nil
+}
+
+// Installation is a GitHub App installation, as the installations API lists it.
+type Installation struct {
+	ID      int `json:"id"`
+	Account struct {
+		Login string `json:"login"`
+	} `json:"account"`
+	RepositorySelection string `json:"repository_selection"`
+}
+
+// DiscoverInstallations calls GET /app/installations to find all installations
+// for the app, following the Link header through every page.
+func DiscoverInstallations(client *http.Client, appTokenSource oauth2.TokenSource) ([]Installation, error) {
+	token, err := appTokenSource.Token()
+	if err != nil {
+		return nil, fmt.Errorf("getting app token: %w", err)
+	}
+
+	var installations []Installation
+	next := "https://api.github.com/app/installations?per_page=100"
+	for next != "" {
+		req, err := newGithubRequest(context.Background(), next, mediaTypeJSON)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var page []Installation
+		err = func() error {
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				_, detail := readErrorBody(resp)
+				return fmt.Errorf("GitHub API returned %s%s", resp.Status, detail)
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+				return fmt.Errorf("decoding installations response: %w", err)
+			}
+			return nil
+		}()
+		if err != nil {
+			return nil, err
+		}
+		installations = append(installations, page...)
+		next = nextPageURL(resp.Header)
+	}
+
+	return installations, nil
+}
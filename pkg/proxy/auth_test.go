@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// installations makes gh answer GET /repos/{owner}/{repo}/installation from
+// byRepo, keyed by "owner/repo", with a 404 for repos no installation covers.
+// It returns the number of lookups per repo.
+func (f *fakeGitHub) installations(byRepo map[string]Installation) func(repo string) int {
+	var mu sync.Mutex
+	lookups := make(map[string]int)
+	f.mux.HandleFunc("GET /repos/{owner}/{repo}/installation", func(w http.ResponseWriter, r *http.Request) {
+		repo := r.PathValue("owner") + "/" + r.PathValue("repo")
+		mu.Lock()
+		lookups[repo]++
+		mu.Unlock()
+		inst, ok := byRepo[repo]
+		if !ok {
+			http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(inst)
+	})
+	return func(repo string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return lookups[repo]
+	}
+}
+
+func testInstallation(id int, login, selection string) Installation {
+	inst := Installation{ID: id, RepositorySelection: selection}
+	inst.Account.Login = login
+	return inst
+}
+
+func TestSelectedRepoInstallation(t *testing.T) {
+	gh := newFakeGitHub(t)
+	minted := gh.mintTokens()
+	selected := testInstallation(11, "acme", "selected")
+	lookups := gh.installations(map[string]Installation{
+		"acme/tool": selected,
+		"acme/lib":  selected,
+	})
+	tm := newAppTokenManager(gh, nil)
+	ctx := context.Background()
+
+	token, err := tm.TokenForRepo(ctx, "acme", "tool")
+	if err != nil || token.AccessToken != "11-1" {
+		t.Fatalf("TokenForRepo(acme/tool) = %v, %v, want installation 11's token", token, err)
+	}
+	if key := tm.cacheKey("acme", "tool"); key != "acme/tool" {
+		t.Errorf("cache key = %q, want the per-repo key for a selected-repos installation", key)
+	}
+
+	// A repo the installation doesn't include must not reuse acme/tool's token
+	if _, err := tm.TokenForRepo(ctx, "acme", "other"); err == nil || !strings.Contains(err.Error(), "doesn't include that repo") {
+		t.Errorf("TokenForRepo(acme/other) error = %v, want the repo not to be covered", err)
+	}
+	if lookups("acme/other") != 1 {
+		t.Errorf("acme/other was looked up %d times, want 1", lookups("acme/other"))
+	}
+
+	// A repo it does include gets its own lookup and cache entry
+	if _, err := tm.TokenForRepo(ctx, "acme", "lib"); err != nil {
+		t.Fatalf("TokenForRepo(acme/lib): %v", err)
+	}
+	if lookups("acme/lib") != 1 {
+		t.Errorf("acme/lib was looked up %d times, want 1", lookups("acme/lib"))
+	}
+
+	// Asking again is served from the cache
+	before := minted.Load()
+	for range 3 {
+		if _, err := tm.TokenForRepo(ctx, "acme", "tool"); err != nil {
+			t.Fatalf("TokenForRepo(acme/tool) again: %v", err)
+		}
+	}
+	if lookups("acme/tool") != 1 || minted.Load() != before {
+		t.Errorf("repeat requests made %d lookups and minted %d tokens, want 1 lookup and none minted",
+			lookups("acme/tool"), minted.Load()-before)
+	}
+	if stats := tm.CacheStats(); stats.Hits != 3 {
+		t.Errorf("cache hits = %d, want 3", stats.Hits)
+	}
+}
+
+func TestInstallationLookupUsesRequestContext(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.mintTokens()
+	gh.installations(map[string]Installation{"acme/tool": testInstallation(11, "acme", "all")})
+	tm := newAppTokenManager(gh, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := tm.TokenForRepo(ctx, "acme", "tool"); err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("TokenForRepo with a cancelled context = %v, want %v", err, context.Canceled)
+	}
+	if _, err := tm.TokenForRepo(context.Background(), "acme", "tool"); err != nil {
+		t.Fatalf("TokenForRepo after the cancelled lookup: %v", err)
+	}
+}
+
+func TestRevokedInstallationIsLookedUpAgain(t *testing.T) {
+	tests := []struct {
+		name        string
+		mintStatus  int
+		wantLookups int64
+		wantToken   string
+	}{
+		{"mint 404", http.StatusNotFound, 2, "12"},
+		{"mint 401", http.StatusUnauthorized, 2, "12"},
+		{"mint 403", http.StatusForbidden, 1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := newFakeGitHub(t)
+			// current is the installation covering acme; mints for any other fail
+			var current atomic.Int64
+			current.Store(11)
+			gh.mux.HandleFunc("POST /app/installations/{id}/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+				if r.PathValue("id") != strconv.FormatInt(current.Load(), 10) {
+					http.Error(w, `{"message":"gone"}`, tt.mintStatus)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				// Already expired, so every TokenForRepo mints again
+				json.NewEncoder(w).Encode(map[string]any{"token": r.PathValue("id"), "expires_at": time.Now()})
+			})
+			var lookups atomic.Int64
+			gh.mux.HandleFunc("GET /repos/acme/tool/installation", func(w http.ResponseWriter, r *http.Request) {
+				lookups.Add(1)
+				json.NewEncoder(w).Encode(testInstallation(int(current.Load()), "acme", "all"))
+			})
+			tm := newAppTokenManager(gh, nil)
+			ctx := context.Background()
+
+			if token, err := tm.TokenForRepo(ctx, "acme", "tool"); err != nil || token.AccessToken != "11" {
+				t.Fatalf("TokenForRepo = %v, %v, want installation 11's token", token, err)
+			}
+
+			// The app is reinstalled: installation 11 is gone and 12 replaces it
+			current.Store(12)
+			token, err := tm.TokenForRepo(ctx, "acme", "tool")
+			if tt.wantToken == "" {
+				if err == nil {
+					t.Errorf("TokenForRepo after a %d mint = %v, want the error", tt.mintStatus, token)
+				}
+			} else if err != nil || token.AccessToken != tt.wantToken {
+				t.Errorf("TokenForRepo after reinstalling = %v, %v, want installation %s's token", token, err, tt.wantToken)
+			}
+			if n := lookups.Load(); n != tt.wantLookups {
+				t.Errorf("acme/tool was looked up %d times, want %d", n, tt.wantLookups)
+			}
+		})
+	}
+}
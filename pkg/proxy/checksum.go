@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// checksumFor returns the "<asset>.sha256" sibling of asset when checksum
+// verification is enabled and the release publishes one, or nil otherwise. A
+// release with several such siblings is an error rather than a reason to skip
+// verification.
+func (p *GithubPrivateReleaseProxy) checksumFor(files []Asset, asset *Asset) (*Asset, error) {
+	if !p.verifyChecksums {
+		return nil, nil
+	}
+	return p.findAsset(files, asset.Name+".sha256")
+}
+
+// expectedDigest downloads a checksum asset and returns the SHA-256 it holds.
+// Both a bare digest and sha256sum's "<digest>  <name>" format are accepted.
+func (p *GithubPrivateReleaseProxy) expectedDigest(ctx context.Context, checksum *Asset) (string, error) {
+	resp, err := p.file(ctx, checksum, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	line, err := bufio.NewReader(io.LimitReader(resp.Body, 4096)).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("reading %s: %w", checksum.Name, err)
+	}
+	digest, _, _ := strings.Cut(strings.TrimSpace(line), " ")
+	if len(digest) != sha256.Size*2 {
+		return "", fmt.Errorf("%s does not contain a SHA-256 digest", checksum.Name)
+	}
+	return strings.ToLower(digest), nil
+}
+
+// spoolVerified copies body to a temporary file, hashing it on the way through,
+// and checks the result against want, the digest published in checksum.
+// Nothing reaches the client until the whole asset has been verified, so a
+// mismatch can still be reported as an error. On success the file is returned
+// positioned at its start; the caller must release it with removeSpool.
+func (p *GithubPrivateReleaseProxy) spoolVerified(ctx context.Context, body io.Reader, checksum *Asset, want string) (*os.File, int64, error) {
+	f, err := os.CreateTemp("", "pkl-proxy-*")
+	if err != nil {
+		return nil, 0, fmt.Errorf("creating spool file: %w", err)
+	}
+	h := sha256.New()
+	n, err := copyContext(ctx, f, io.TeeReader(body, h))
+	if err != nil {
+		removeSpool(f)
+		return nil, 0, fmt.Errorf("downloading asset: %w", err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		removeSpool(f)
+		return nil, 0, fmt.Errorf("checksum mismatch: %s says %s, downloaded content is %s", checksum.Name, want, got)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		removeSpool(f)
+		return nil, 0, fmt.Errorf("rewinding spool file: %w", err)
+	}
+	return f, n, nil
+}
+
+func removeSpool(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
@@ -0,0 +1,482 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apple/pkl-go/pkl"
+	"github.com/bmurray/pkl-proxy/gen/appconfig"
+)
+
+// configSchemaVersion is the config schema this binary understands. Bump it and
+// register a migration in configMigrations when a field changes meaning.
+const configSchemaVersion = 1
+
+// configMigrations upgrade a config written for the keyed schema version to the
+// next version.
+var configMigrations = map[int]func(cfg *appconfig.AppConfig){}
+
+// configFiles is the priority-ordered list of config file names to try.
+var configFiles = []struct {
+	name   string
+	loader func(path string) (*appconfig.AppConfig, error)
+}{
+	{"config.pklbin", loadPkl},
+	{"config.pkl", loadPkl},
+	{"config.json", loadJSON},
+}
+
+// LoadConfig loads the first config file found in configDir (see configFiles),
+// then applies environment overrides and defaults and checks the result.
+func LoadConfig(configDir string) (*appconfig.AppConfig, error) {
+	for _, cf := range configFiles {
+		path := filepath.Join(configDir, cf.name)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		cfg, err := cf.loader(path)
+		if err != nil {
+			return nil, err
+		}
+		migrateConfig(cfg, path)
+		if err := applyEnvOverrides(cfg); err != nil {
+			return nil, fmt.Errorf("invalid config %s: %w", path, err)
+		}
+		applyDefaults(cfg)
+		if cfg.ListenAddress, err = NormalizeListenAddress(cfg.ListenAddress); err != nil {
+			return nil, fmt.Errorf("invalid config %s: listenAddress: %w", path, err)
+		}
+		if err := readTokenFile(configDir, cfg); err != nil {
+			return nil, fmt.Errorf("invalid config %s: %w", path, err)
+		}
+		if err := checkTLS(configDir, cfg); err != nil {
+			return nil, fmt.Errorf("invalid config %s: %w", path, err)
+		}
+		if err := checkDurations(cfg); err != nil {
+			return nil, fmt.Errorf("invalid config %s: %w", path, err)
+		}
+		if err := checkLimits(cfg); err != nil {
+			return nil, fmt.Errorf("invalid config %s: %w", path, err)
+		}
+		return cfg, nil
+	}
+	return nil, fmt.Errorf("no config file found in %s (tried config.pklbin, config.pkl, config.json)", configDir)
+}
+
+// PklProperties are external properties passed to the config module, readable
+// in pkl as read("prop:<name>"). Set from --pkl-property and PKL_PROXY_PKL_PROPERTIES.
+var PklProperties = map[string]string{}
+
+// pklPropertiesEnv holds comma-separated key=value external properties.
+// Properties given with --pkl-property override these.
+const pklPropertiesEnv = "PKL_PROXY_PKL_PROPERTIES"
+
+func loadPkl(path string) (*appconfig.AppConfig, error) {
+	ctx := context.Background()
+	evaluator, err := pkl.NewEvaluator(ctx, pkl.PreconfiguredOptions, func(opts *pkl.EvaluatorOptions) {
+		if opts.Properties == nil {
+			opts.Properties = map[string]string{}
+		}
+		for _, kv := range strings.Split(os.Getenv(pklPropertiesEnv), ",") {
+			if k, v, ok := strings.Cut(kv, "="); ok {
+				opts.Properties[strings.TrimSpace(k)] = v
+			}
+		}
+		maps.Copy(opts.Properties, PklProperties)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating pkl evaluator: %w", err)
+	}
+	defer evaluator.Close()
+
+	cfg, err := appconfig.Load(ctx, evaluator, pkl.FileSource(path))
+	if err != nil {
+		return nil, fmt.Errorf("error loading pkl config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+func loadJSON(path string) (*appconfig.AppConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening json config %s: %w", path, err)
+	}
+	defer f.Close()
+
+	// Booleans that default to true in AppConfig.pkl are set up front, since
+	// decoding leaves fields missing from the file untouched
+	cfg := appconfig.AppConfig{LoopbackOnly: true}
+	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("error decoding json config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// migrateConfig warns when a config was written for a different schema version
+// and upgrades older configs one version at a time.
+func migrateConfig(cfg *appconfig.AppConfig, path string) {
+	if cfg.SchemaVersion == nil {
+		return
+	}
+	version := *cfg.SchemaVersion
+	if version > configSchemaVersion {
+		fmt.Printf("Warning: %s has schemaVersion %d, but this pkl-proxy only understands up to %d; upgrade pkl-proxy\n",
+			path, version, configSchemaVersion)
+		return
+	}
+	if version < configSchemaVersion {
+		fmt.Printf("Warning: %s has schemaVersion %d; migrating to %d. Update the file to silence this warning.\n",
+			path, version, configSchemaVersion)
+	}
+	for ; version < configSchemaVersion; version++ {
+		if migrate, ok := configMigrations[version]; ok {
+			migrate(cfg)
+		}
+	}
+}
+
+// UsesApp reports whether cfg authenticates as a GitHub App rather than with a
+// personal access token. The app wins when both are configured.
+func UsesApp(cfg *appconfig.AppConfig) bool {
+	return cfg.AppId != nil || cfg.ClientId != nil
+}
+
+// readTokenFile fills in token from tokenFile (relative to configDir) when only
+// the file is given.
+func readTokenFile(configDir string, cfg *appconfig.AppConfig) error {
+	if cfg.TokenFile == nil || cfg.Token != nil {
+		return nil
+	}
+	path := *cfg.TokenFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(configDir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("tokenFile: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	cfg.Token = &token
+	return nil
+}
+
+// TLSEnabled reports whether the proxy serves HTTPS.
+func TLSEnabled(cfg *appconfig.AppConfig) bool {
+	return cfg.TlsCertFile != nil && cfg.TlsKeyFile != nil
+}
+
+// checkTLS requires tlsCertFile and tlsKeyFile to be set together, resolves
+// them against configDir, and checks tlsMinVersion and tlsCipherSuites.
+func checkTLS(configDir string, cfg *appconfig.AppConfig) error {
+	if (cfg.TlsCertFile == nil) != (cfg.TlsKeyFile == nil) {
+		return fmt.Errorf("tlsCertFile and tlsKeyFile must be set together")
+	}
+	for _, p := range []*string{cfg.TlsCertFile, cfg.TlsKeyFile} {
+		if p != nil && !filepath.IsAbs(*p) {
+			*p = filepath.Join(configDir, *p)
+		}
+	}
+	_, err := ServerTLSConfig(cfg)
+	return err
+}
+
+// tlsVersions are the tlsMinVersion values accepted. Older versions are
+// insecure and can't be enabled.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// defaultCipherSuites are offered to TLS 1.2 clients when tlsCipherSuites is
+// empty: forward secret AEAD ones. TLS 1.3 suites aren't configurable and are
+// all fine.
+var defaultCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// ServerTLSConfig returns the listener's TLS settings from tlsMinVersion and
+// tlsCipherSuites; the caller adds the certificate.
+func ServerTLSConfig(cfg *appconfig.AppConfig) (*tls.Config, error) {
+	version, ok := tlsVersions[cfg.TlsMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("tlsMinVersion must be 1.2 or 1.3, got %q", cfg.TlsMinVersion)
+	}
+	suites := defaultCipherSuites
+	if len(cfg.TlsCipherSuites) > 0 {
+		suites = nil
+		for _, name := range cfg.TlsCipherSuites {
+			id, err := tls12CipherSuite(name)
+			if err != nil {
+				return nil, fmt.Errorf("tlsCipherSuites: %w", err)
+			}
+			suites = append(suites, id)
+		}
+	}
+	return &tls.Config{MinVersion: version, CipherSuites: suites}, nil
+}
+
+// tls12CipherSuite returns the ID of the TLS 1.2 cipher suite called name,
+// refusing those crypto/tls lists as insecure.
+func tls12CipherSuite(name string) (uint16, error) {
+	for _, s := range tls.CipherSuites() {
+		if s.Name != name {
+			continue
+		}
+		if !slices.Contains(s.SupportedVersions, tls.VersionTLS12) {
+			return 0, fmt.Errorf("%s is a TLS 1.3 suite, which can't be configured", name)
+		}
+		return s.ID, nil
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		if s.Name == name {
+			return 0, fmt.Errorf("%s is insecure", name)
+		}
+	}
+	return 0, fmt.Errorf("unknown cipher suite %q", name)
+}
+
+// ReadKeyFile reads a private key file, resolving relative paths against configDir.
+func ReadKeyFile(configDir, path string) ([]byte, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(configDir, path)
+	}
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading private key file: %w", err)
+	}
+	return key, nil
+}
+
+// privateKeyEnv may hold a PEM private key, or a path to one. When set, it is
+// used instead of the key file named by privateKey.
+const privateKeyEnv = "PKL_PROXY_PRIVATE_KEY"
+
+// ReadPrivateKey loads the GitHub App private key: from PKL_PROXY_PRIVATE_KEY if
+// set, otherwise inline from privateKeyPem, otherwise from the privateKey file
+// (relative to configDir).
+func ReadPrivateKey(configDir string, cfg *appconfig.AppConfig) ([]byte, error) {
+	if env := os.Getenv(privateKeyEnv); env != "" {
+		if strings.Contains(env, "-----BEGIN") {
+			return []byte(env), nil
+		}
+		privateKey, err := os.ReadFile(env)
+		if err != nil {
+			return nil, fmt.Errorf("reading private key from %s: %w", privateKeyEnv, err)
+		}
+		return privateKey, nil
+	}
+
+	if cfg.PrivateKeyPem != nil {
+		if cfg.PrivateKey != nil {
+			fmt.Println("Warning: both privateKeyPem and privateKey are set; using privateKeyPem")
+		}
+		return []byte(*cfg.PrivateKeyPem), nil
+	}
+
+	privateKeyPath := "(unset)"
+	if cfg.PrivateKey != nil {
+		privateKeyPath = *cfg.PrivateKey
+		if !filepath.IsAbs(privateKeyPath) {
+			privateKeyPath = filepath.Join(configDir, privateKeyPath)
+		}
+		privateKey, err := os.ReadFile(privateKeyPath)
+		if err == nil {
+			return privateKey, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("reading private key file: %w", err)
+		}
+	}
+	return nil, fmt.Errorf("private key %s not found and %s is not set; set privateKeyPem, point privateKey at an existing file, or set %s to a PEM key or its path",
+		privateKeyPath, privateKeyEnv, privateKeyEnv)
+}
+
+// Environment variables that override config file values, for deployments
+// (e.g. containers) where changing the file is awkward. PKL_PROXY_PRIVATE_KEY
+// is read by ReadPrivateKey instead, since it may hold the key itself.
+const (
+	ListenAddressEnv  = "PKL_PROXY_LISTEN_ADDRESS"
+	appIdEnv          = "PKL_PROXY_APP_ID"
+	clientIdEnv       = "PKL_PROXY_CLIENT_ID"
+	installationIdEnv = "PKL_PROXY_INSTALLATION_ID"
+)
+
+// applyEnvOverrides replaces config values with those of any override
+// environment variables that are set.
+func applyEnvOverrides(cfg *appconfig.AppConfig) error {
+	if v := os.Getenv(ListenAddressEnv); v != "" {
+		// run hands its command the address with a scheme when serving TLS
+		_, cfg.ListenAddress = CutScheme(v)
+	}
+	if v := os.Getenv(appIdEnv); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s must be a number, got %q", appIdEnv, v)
+		}
+		cfg.AppId = &id
+	}
+	if v := os.Getenv(clientIdEnv); v != "" {
+		cfg.ClientId = &v
+	}
+	if v := os.Getenv(installationIdEnv); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s must be a number, got %q", installationIdEnv, v)
+		}
+		cfg.InstallationId = &id
+	}
+	return nil
+}
+
+func applyDefaults(cfg *appconfig.AppConfig) {
+	if cfg.ListenAddress == "" {
+		cfg.ListenAddress = net.JoinHostPort("localhost", defaultListenPort)
+	}
+	if cfg.DuplicateAssets == "" {
+		cfg.DuplicateAssets = "error"
+	}
+	if cfg.MaxReleasesScanned == 0 {
+		cfg.MaxReleasesScanned = 1000
+	}
+	if cfg.LogFormat == "" {
+		cfg.LogFormat = "text"
+	}
+	if cfg.ProgressLogLevel == "" {
+		cfg.ProgressLogLevel = "info"
+	}
+	if cfg.MaxConcurrentUpstream == 0 {
+		cfg.MaxConcurrentUpstream = 8
+	}
+	if cfg.MaxUpstreamAttempts == 0 {
+		cfg.MaxUpstreamAttempts = 3
+	}
+	if cfg.UpstreamTimeout == "" {
+		cfg.UpstreamTimeout = "30s"
+	}
+	if cfg.ShutdownTimeout == "" {
+		cfg.ShutdownTimeout = "5s"
+	}
+	if cfg.ReleaseCacheTTL == "" {
+		cfg.ReleaseCacheTTL = "5m"
+	}
+	if cfg.TlsMinVersion == "" {
+		cfg.TlsMinVersion = "1.2"
+	}
+}
+
+// checkDurations verifies that every duration field parses, so later callers
+// can use DurationOr without handling errors.
+func checkDurations(cfg *appconfig.AppConfig) error {
+	fields := map[string]*string{
+		"progressInterval":   cfg.ProgressInterval,
+		"monitorInterval":    cfg.MonitorInterval,
+		"tokenStatsInterval": cfg.TokenStatsInterval,
+		"releaseCacheTTL":    &cfg.ReleaseCacheTTL,
+		"shutdownTimeout":    &cfg.ShutdownTimeout,
+		"upstreamTimeout":    &cfg.UpstreamTimeout,
+	}
+	for name, value := range fields {
+		if value == nil {
+			continue
+		}
+		if _, err := time.ParseDuration(*value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// checkLimits enforces the bounds config/AppConfig.pkl puts on concurrency
+// limits, which JSON configs don't go through: a limit below 1 would panic or
+// stall every request.
+func checkLimits(cfg *appconfig.AppConfig) error {
+	if cfg.MaxConcurrentUpstream < 1 {
+		return fmt.Errorf("maxConcurrentUpstream must be at least 1, got %d", cfg.MaxConcurrentUpstream)
+	}
+	if cfg.MaxInFlightRequests != nil && *cfg.MaxInFlightRequests < 1 {
+		return fmt.Errorf("maxInFlightRequests must be at least 1, got %d", *cfg.MaxInFlightRequests)
+	}
+	if cfg.MaxQueuedRequests < 0 {
+		return fmt.Errorf("maxQueuedRequests must not be negative, got %d", cfg.MaxQueuedRequests)
+	}
+	return nil
+}
+
+// DurationOr returns the parsed duration field, or def if the field is unset.
+func DurationOr(value *string, def time.Duration) time.Duration {
+	if value == nil {
+		return def
+	}
+	d, err := time.ParseDuration(*value)
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+// defaultListenPort is the port used when listenAddress doesn't name one.
+const defaultListenPort = "9443"
+
+// NormalizeListenAddress turns the forms listenAddress accepts into a host:port
+// that both net.Listen and clients understand. A bare port ("9443") listens on
+// localhost, a bare host ("localhost", "::1", "[::1]") gets defaultListenPort,
+// ":9443" keeps listening on all interfaces, and IPv6 literals are bracketed.
+func NormalizeListenAddress(addr string) (string, error) {
+	addr = strings.TrimSpace(addr)
+	if _, err := strconv.Atoi(addr); err == nil {
+		addr = net.JoinHostPort("localhost", addr)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		// No port: a hostname, or an IPv6 literal with or without brackets
+		if strings.HasPrefix(addr, "[") != strings.HasSuffix(addr, "]") {
+			return "", fmt.Errorf("malformed address %q", addr)
+		}
+		host = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+		port = defaultListenPort
+	}
+	if strings.ContainsAny(host, "[]") {
+		return "", fmt.Errorf("malformed address %q", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// ClientAddress turns a listen address into one a client can connect to.
+// Binds to all interfaces (":9443", "0.0.0.0:9443") become "localhost:9443".
+func ClientAddress(listenAddress string) string {
+	host, port, err := net.SplitHostPort(listenAddress)
+	if err != nil {
+		return listenAddress
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		return net.JoinHostPort("localhost", port)
+	}
+	return listenAddress
+}
+
+// CutScheme splits a rewrite target into its scheme and address. A bare
+// host:port is plain HTTP.
+func CutScheme(target string) (scheme, address string) {
+	if scheme, address, ok := strings.Cut(target, "://"); ok {
+		return scheme, address
+	}
+	return "http", target
+}
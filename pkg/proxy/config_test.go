@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bmurray/pkl-proxy/gen/appconfig"
+)
+
+func TestNormalizeListenAddress(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "8080", want: "localhost:8080"},
+		{in: " 8080 ", want: "localhost:8080"},
+		{in: ":8080", want: ":8080"},
+		{in: "localhost", want: "localhost:9443"},
+		{in: "localhost:0", want: "localhost:0"},
+		{in: "0.0.0.0:80", want: "0.0.0.0:80"},
+		{in: "::1", want: "[::1]:9443"},
+		{in: "[::1]", want: "[::1]:9443"},
+		{in: "[::1]:80", want: "[::1]:80"},
+		{in: "fe80::1", want: "[fe80::1]:9443"},
+		{in: "localhost:99999", wantErr: true},
+		{in: "localhost:http", wantErr: true},
+		{in: "[::1", wantErr: true},
+		{in: "::1]", wantErr: true},
+		{in: "[[::1]]:80", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeListenAddress(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("NormalizeListenAddress(%q) = %q, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeListenAddress(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigChecksValues(t *testing.T) {
+	tests := []struct {
+		config  string
+		wantErr string
+	}{
+		{config: `{"token":"t"}`},
+		{config: `{"token":"t","maxConcurrentUpstream":2}`},
+		{config: `{"token":"t","maxConcurrentUpstream":-1}`, wantErr: "maxConcurrentUpstream"},
+		{config: `{"token":"t","maxInFlightRequests":4,"maxQueuedRequests":0}`},
+		{config: `{"token":"t","maxInFlightRequests":0}`, wantErr: "maxInFlightRequests"},
+		{config: `{"token":"t","maxInFlightRequests":-1}`, wantErr: "maxInFlightRequests"},
+		{config: `{"token":"t","maxInFlightRequests":4,"maxQueuedRequests":-1}`, wantErr: "maxQueuedRequests"},
+		{config: `{"token":"t","tlsMinVersion":"1.0"}`, wantErr: "tlsMinVersion"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(tt.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadConfig(dir)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("LoadConfig(%s): %v", tt.config, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("LoadConfig(%s) error = %v, want one about %s", tt.config, err, tt.wantErr)
+		}
+	}
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	tests := []struct {
+		minVersion  string
+		suites      []string
+		wantVersion uint16
+		wantSuites  []uint16
+		wantErr     string
+	}{
+		{minVersion: "1.2", wantVersion: tls.VersionTLS12, wantSuites: defaultCipherSuites},
+		{minVersion: "1.3", wantVersion: tls.VersionTLS13, wantSuites: defaultCipherSuites},
+		{
+			minVersion:  "1.2",
+			suites:      []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384", "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"},
+			wantVersion: tls.VersionTLS12,
+			wantSuites:  []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256},
+		},
+		{minVersion: "1.1", wantErr: "tlsMinVersion"},
+		{minVersion: "1.0", wantErr: "tlsMinVersion"},
+		{minVersion: "tls1.2", wantErr: "tlsMinVersion"},
+		{minVersion: "1.2", suites: []string{"TLS_RSA_WITH_AES_128_CBC_SHA"}, wantErr: "insecure"},
+		{minVersion: "1.2", suites: []string{"TLS_AES_128_GCM_SHA256"}, wantErr: "TLS 1.3 suite"},
+		{minVersion: "1.2", suites: []string{"TLS_MADE_UP"}, wantErr: "unknown cipher suite"},
+	}
+	for _, tt := range tests {
+		got, err := ServerTLSConfig(&appconfig.AppConfig{TlsMinVersion: tt.minVersion, TlsCipherSuites: tt.suites})
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ServerTLSConfig(%s, %q) error = %v, want one mentioning %q", tt.minVersion, tt.suites, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ServerTLSConfig(%s, %q): %v", tt.minVersion, tt.suites, err)
+			continue
+		}
+		if got.MinVersion != tt.wantVersion || !slices.Equal(got.CipherSuites, tt.wantSuites) {
+			t.Errorf("ServerTLSConfig(%s, %q) = version %x, suites %x, want %x, %x",
+				tt.minVersion, tt.suites, got.MinVersion, got.CipherSuites, tt.wantVersion, tt.wantSuites)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// mediaTypeRaw asks the contents endpoint for a file's bytes rather than its
+// base64-encoded JSON description.
+const mediaTypeRaw = "application/vnd.github.raw"
+
+// contentsHandler streams a single file from the repo at a ref (a tag, branch or
+// commit), from GitHub's /repos/{owner}/{repo}/contents/{path}?ref={ref}.
+func (p *GithubPrivateReleaseProxy) contentsHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	repo := r.PathValue("repo")
+	ref := r.PathValue("tag")
+	file := r.PathValue("path")
+	p.logFor(r.Context()).Debug("Handling request for repo file", "user", user, "repo", repo, "ref", ref, "path", file)
+
+	u, err := url.JoinPath("https://api.github.com/repos/", user, repo, "contents", file)
+	if err != nil {
+		http.Error(w, "Invalid file path", http.StatusBadRequest)
+		return
+	}
+	u += "?ref=" + url.QueryEscape(ref)
+	ctx := withRepo(r.Context(), user, repo)
+	req, err := newGithubRequest(ctx, u, mediaTypeRaw)
+	if err != nil {
+		p.upstreamError(ctx, w, "Error creating contents request", err)
+		return
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		req.Header.Set("If-None-Match", inm)
+	}
+	if !p.upstream.acquire(ctx) {
+		p.upstreamError(ctx, w, "Error fetching file contents", context.Cause(ctx))
+		return
+	}
+	defer p.upstream.release()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.upstreamError(ctx, w, "Error fetching file contents", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		http.Error(w, fmt.Sprintf("File %q not found at %q", file, ref), http.StatusNotFound)
+		return
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		copyAssetHeaders(w.Header(), resp.Header)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if err := checkRateLimit(resp); err != nil {
+		p.upstreamError(ctx, w, "Error fetching file contents", err)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, detail := readErrorBody(resp)
+		p.logFor(ctx).Debug("GitHub API error response", "url", u, "status", resp.Status, "body", body)
+		p.upstreamError(ctx, w, "Error fetching file contents", fmt.Errorf("GitHub returned %s%s", resp.Status, detail))
+		return
+	}
+
+	if err := p.capBody(resp); err != nil {
+		assetTooLarge(w, resp.ContentLength, p.maxAssetSize)
+		return
+	}
+
+	copyAssetHeaders(w.Header(), resp.Header)
+	p.streams.Add(1)
+	n, err := copyContext(ctx, w, resp.Body)
+	p.streams.Add(-1)
+	if errors.Is(err, errAssetTooLarge) {
+		// Too late for a 413; abort so the truncated file isn't taken for a whole one
+		p.logFor(ctx).Error("Repo file exceeded maxAssetSize mid-stream", "bytes", n, "maxAssetSize", p.maxAssetSize)
+		panic(http.ErrAbortHandler)
+	}
+	p.metrics.servedBytes(n)
+}
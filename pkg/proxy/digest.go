@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// digestCache remembers the SHA-256 of assets keyed by asset URL, along with
+// the ETag of the content that was hashed.
+type digestCache struct {
+	mu      sync.Mutex
+	entries map[string]digestEntry
+}
+
+type digestEntry struct {
+	etag   string
+	digest string
+}
+
+func newDigestCache() *digestCache {
+	return &digestCache{entries: make(map[string]digestEntry)}
+}
+
+func (c *digestCache) get(assetURL string) (digestEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[assetURL]
+	return e, ok
+}
+
+func (c *digestCache) put(assetURL string, e digestEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[assetURL] = e
+}
+
+// serveDigest responds with the SHA-256 of asset in sha256sum format. The asset
+// is streamed through the hasher rather than buffered. A cached digest is
+// revalidated with If-None-Match so unchanged assets aren't downloaded again.
+func (p *GithubPrivateReleaseProxy) serveDigest(ctx context.Context, w http.ResponseWriter, asset *Asset) {
+	cached, ok := p.digests.get(asset.URL)
+	var extra http.Header
+	if ok {
+		extra = http.Header{"If-None-Match": {cached.etag}}
+	}
+
+	resp, err := p.file(ctx, asset, extra)
+	if errors.Is(err, errAssetTooLarge) {
+		assetTooLarge(w, resp.ContentLength, p.maxAssetSize)
+		return
+	}
+	if err != nil {
+		p.upstreamError(ctx, w, "Error fetching file content", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	digest := cached.digest
+	if resp.StatusCode != http.StatusNotModified {
+		h := sha256.New()
+		_, err := io.Copy(h, resp.Body)
+		if errors.Is(err, errAssetTooLarge) {
+			assetTooLarge(w, -1, p.maxAssetSize)
+			return
+		}
+		if err != nil {
+			p.logFor(ctx).Error("Error hashing file content", "error", err)
+			http.Error(w, "Error hashing file content: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		digest = hex.EncodeToString(h.Sum(nil))
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			p.digests.put(asset.URL, digestEntry{etag: etag, digest: digest})
+		}
+	}
+
+	p.logFor(ctx).Debug("Serving computed digest", "file", asset.Name, "sha256", digest)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "%s  %s\n", digest, asset.Name)
+}
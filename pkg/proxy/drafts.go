@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// draftRelease finds a draft release whose tag name or release name equals tag.
+// Drafts aren't reachable through /releases/tags/{tag}, so this pages through
+// the release list instead, newest first, up to maxReleasesScanned releases.
+func (p *GithubPrivateReleaseProxy) draftRelease(ctx context.Context, user, repo, tag string) (*Release, error) {
+	ux, err := url.Parse("https://api.github.com/repos/")
+	if err != nil {
+		return nil, fmt.Errorf("error parsing base URL: %w", err)
+	}
+	ux = ux.JoinPath(user, repo, "releases")
+	ux.RawQuery = "per_page=100"
+
+	scanned := 0
+	for next := ux.String(); next != ""; {
+		if scanned >= p.maxReleasesScanned {
+			p.logFor(ctx).Warn("Stopped scanning releases at maxReleasesScanned; older drafts were not considered",
+				"user", user, "repo", repo, "tag", tag, "maxReleasesScanned", p.maxReleasesScanned)
+			break
+		}
+
+		var page []Release
+		header, err := p.apiGet(ctx, next, &page, nil)
+		if err != nil {
+			return nil, err
+		}
+		for i := range page {
+			release := &page[i]
+			if release.Draft && (release.TagName == tag || release.Name == tag) {
+				p.logFor(ctx).Warn("Serving draft release", "user", user, "repo", repo, "tag", tag)
+				return release, nil
+			}
+		}
+		scanned += len(page)
+		next = nextPageURL(header)
+	}
+	return nil, fmt.Errorf("no release or draft release named %q in %s/%s: %w", tag, user, repo, ErrNotFound)
+}
+
+// nextPageURL returns the rel="next" URL from a GitHub Link header, or "" on the last page.
+func nextPageURL(header http.Header) string {
+	for _, link := range strings.Split(header.Get("Link"), ",") {
+		target, params, ok := strings.Cut(link, ";")
+		if !ok {
+			continue
+		}
+		if strings.Contains(params, `rel="next"`) {
+			return strings.Trim(strings.TrimSpace(target), "<>")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Media types for the Accept header of upstream GitHub calls.
+const (
+	// mediaTypeJSON is the versioned JSON media type for REST API responses.
+	mediaTypeJSON = "application/vnd.github+json"
+	// mediaTypeBinary asks the release asset endpoint for the asset bytes.
+	mediaTypeBinary = "application/octet-stream"
+)
+
+// assetMediaTypes are the media types clients may ask the asset endpoint for
+// with ?accept=. The binary type, the default, gets the asset itself; the
+// others get its metadata as JSON.
+var assetMediaTypes = []string{mediaTypeBinary, mediaTypeJSON, "application/json"}
+
+// newGithubRequest builds a GET request to GitHub that accepts the given media type.
+func newGithubRequest(ctx context.Context, url, accept string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	return req, nil
+}
+
+// maxErrorBody bounds how much of a failed GitHub response is read for its
+// error message.
+const maxErrorBody = 1024
+
+// readErrorBody reads up to maxErrorBody bytes of a failed GitHub response. It
+// returns them for debug logging, along with a suffix for error messages:
+// GitHub's JSON "message" (e.g. "Resource not accessible by integration") when
+// there is one, otherwise the body itself, or nothing for an empty body.
+func readErrorBody(resp *http.Response) (body, detail string) {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	body = string(data)
+	var parsed struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(data, &parsed) == nil && parsed.Message != "" {
+		return body, ": " + parsed.Message
+	}
+	if trimmed := strings.Join(strings.Fields(body), " "); trimmed != "" {
+		if len(data) == maxErrorBody {
+			trimmed += "..."
+		}
+		return body, ": " + trimmed
+	}
+	return body, ""
+}
+
+// rateLimitError reports that GitHub refused a request because the credentials
+// used up their rate limit.
+type rateLimitError struct {
+	reset time.Time // when GitHub will accept requests again
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("GitHub API rate limit exceeded, resets at %s", e.reset.Format(time.RFC3339))
+}
+
+// retryAfter is the Retry-After value, in whole seconds, until the limit resets.
+func (e *rateLimitError) retryAfter() string {
+	secs := int64(math.Ceil(time.Until(e.reset).Seconds()))
+	return strconv.FormatInt(max(secs, 1), 10)
+}
+
+// checkRateLimit returns a *rateLimitError if resp is GitHub refusing a request
+// for exceeding the primary (X-RateLimit-*) or secondary (Retry-After) rate limit.
+func checkRateLimit(resp *http.Response) error {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+	if secs, err := strconv.ParseInt(resp.Header.Get("Retry-After"), 10, 64); err == nil {
+		return &rateLimitError{reset: time.Now().Add(time.Duration(secs) * time.Second)}
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return nil
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return &rateLimitError{reset: time.Now().Add(time.Minute)}
+	}
+	return &rateLimitError{reset: time.Unix(reset, 0)}
+}
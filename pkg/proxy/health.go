@@ -0,0 +1,26 @@
+package proxy
+
+import "net/http"
+
+// monitoringPaths are served ahead of the request limiter and oneshot check so a
+// busy or finished proxy still answers its supervisor.
+var monitoringPaths = map[string]bool{"/livez": true, "/healthz": true, "/metrics": true}
+
+// probePaths answer without proxyAuthToken, so health probes don't need the secret.
+var probePaths = map[string]bool{"/livez": true, "/healthz": true}
+
+// livenessHandler reports that the server is up. It never calls GitHub.
+func (p *GithubPrivateReleaseProxy) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readinessHandler reports whether the proxy can mint an app token, the first
+// step of serving any request through the GitHub App.
+func (p *GithubPrivateReleaseProxy) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	if err := p.tokens.ready(); err != nil {
+		p.logFor(r.Context()).Warn("Readiness check failed", "error", err)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
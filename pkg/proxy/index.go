@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type indexAsset struct {
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"contentType"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	Path        string    `json:"path"` // proxy path that serves the asset
+}
+
+var indexTmpl = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{ .Repo }} {{ .Tag }}</title></head>
+<body>
+<h1>{{ .Repo }} {{ .Tag }}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Content type</th><th>Updated</th></tr>
+{{- range .Assets }}
+<tr><td><a href="{{ .Path }}">{{ .Name }}</a></td><td>{{ .Size }}</td><td>{{ .ContentType }}</td><td>{{ .UpdatedAt.Format "2006-01-02 15:04:05Z07:00" }}</td></tr>
+{{- end }}
+</table>
+</body>
+</html>
+`))
+
+// indexHandler lists the assets of a release for /{user}/{repo}/{tag}/, as JSON
+// or, for clients that prefer it (browsers), an HTML table linking each asset.
+func (p *GithubPrivateReleaseProxy) indexHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	repo := r.PathValue("repo")
+	tag := r.PathValue("tag")
+	p.logFor(r.Context()).Debug("Handling request for release index", "user", user, "repo", repo, "tag", tag)
+
+	ctx := withRepo(r.Context(), user, repo)
+	files, err := p.files(ctx, user, repo, tag)
+	if errors.Is(err, ErrNotFound) {
+		releaseNotFound(w, tag)
+		return
+	}
+	if err != nil {
+		p.upstreamError(ctx, w, "Error fetching release files", err)
+		return
+	}
+
+	assets := make([]indexAsset, len(files))
+	for i, f := range files {
+		assets[i] = indexAsset{
+			Name:        f.Name,
+			Size:        f.Size,
+			ContentType: f.ContentType,
+			UpdatedAt:   f.UpdatedAt,
+			Path:        "/" + user + "/" + repo + "/" + tag + "/" + url.PathEscape(f.Name),
+		}
+	}
+
+	if !prefersHTML(r.Header.Get("Accept")) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"repo":   user + "/" + repo,
+			"tag":    tag,
+			"assets": assets,
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	indexTmpl.Execute(w, map[string]any{
+		"Repo":   user + "/" + repo,
+		"Tag":    tag,
+		"Assets": assets,
+	})
+}
+
+// prefersHTML reports whether an Accept header asks for text/html ahead of
+// application/json. JSON is the default for anything else, including no header.
+func prefersHTML(accept string) bool {
+	for _, mediaType := range parseAccept(accept) {
+		switch mediaType {
+		case "text/html":
+			return true
+		case "application/json":
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// installationStoreFile is the name of the on-disk installation cache in the
+// config directory.
+const installationStoreFile = "installations.json"
+
+// installationStore persists the owner (or, for installations covering
+// selected repos only, owner/repo) -> installation ID mapping so restarts
+// skip installation discovery. Only IDs are stored; tokens expire within the
+// hour and are always minted afresh. Writes are best-effort: the first failure
+// (e.g. a read-only config directory) is reported once and the store carries on
+// in memory only. A nil store remembers nothing.
+type installationStore struct {
+	path string
+
+	mu             sync.Mutex
+	ids            map[string]int // TokenManager cacheKey -> installation ID
+	writesDisabled bool
+}
+
+// loadInstallationStore reads the cache at path. A missing or unreadable file
+// starts an empty cache.
+func loadInstallationStore(path string) *installationStore {
+	s := &installationStore{path: path, ids: make(map[string]int)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Warning: could not read installation cache: %v\n", err)
+		}
+		return s
+	}
+	if err := json.Unmarshal(data, &s.ids); err != nil {
+		fmt.Printf("Warning: ignoring corrupt installation cache %s: %v\n", path, err)
+		s.ids = make(map[string]int)
+	}
+	return s
+}
+
+func (s *installationStore) get(owner string) (int, bool) {
+	if s == nil {
+		return 0, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.ids[owner]
+	return id, ok
+}
+
+func (s *installationStore) put(owner string, id int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur, ok := s.ids[owner]; ok && cur == id {
+		return
+	}
+	s.ids[owner] = id
+	s.save()
+}
+
+func (s *installationStore) remove(owner string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.ids[owner]; !ok {
+		return
+	}
+	delete(s.ids, owner)
+	s.save()
+}
+
+// save writes the cache atomically. Callers hold s.mu.
+func (s *installationStore) save() {
+	if s.writesDisabled {
+		return
+	}
+	err := func() error {
+		data, err := json.MarshalIndent(s.ids, "", "  ")
+		if err != nil {
+			return err
+		}
+		tmp, err := os.CreateTemp(filepath.Dir(s.path), installationStoreFile+".*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.Write(data); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		return os.Rename(tmp.Name(), s.path)
+	}()
+	if err != nil {
+		fmt.Printf("Warning: could not write installation cache (%v); continuing without persisting it\n", err)
+		s.writesDisabled = true
+	}
+}
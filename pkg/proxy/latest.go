@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	// maxLatestRepos caps how many repos one latest-releases request may ask about.
+	maxLatestRepos = 50
+	// latestConcurrency bounds the concurrent GitHub calls per latest-releases request.
+	latestConcurrency = 4
+)
+
+type latestRelease struct {
+	Repo   string   `json:"repo"`
+	Tag    string   `json:"tag,omitempty"`
+	Assets []string `json:"assets,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// latestReleasesHandler reports the latest release tag of every repo named in a
+// "repo=owner/repo" query parameter. With "assets=true", each entry also lists
+// the release's asset names. Lookups that fail are reported per entry rather
+// than failing the whole response.
+func (p *GithubPrivateReleaseProxy) latestReleasesHandler(w http.ResponseWriter, r *http.Request) {
+	repos := r.URL.Query()["repo"]
+	withAssets := r.URL.Query().Get("assets") == "true"
+
+	if len(repos) == 0 {
+		http.Error(w, "At least one repo=owner/repo query parameter is required", http.StatusBadRequest)
+		return
+	}
+	if len(repos) > maxLatestRepos {
+		http.Error(w, fmt.Sprintf("At most %d repos may be requested at once", maxLatestRepos), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]latestRelease, len(repos))
+	sem := make(chan struct{}, latestConcurrency)
+	var wg sync.WaitGroup
+	for i, fullName := range repos {
+		results[i].Repo = fullName
+		owner, repo, ok := strings.Cut(fullName, "/")
+		if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+			results[i].Error = "expected owner/repo"
+			continue
+		}
+		if !p.repoAllowed(owner, repo) {
+			results[i].Error = "not served by this proxy"
+			continue
+		}
+
+		wg.Add(1)
+		go func(res *latestRelease) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			ctx := withRepo(r.Context(), owner, repo)
+			release, err := p.release(ctx, owner, repo, "latest")
+			if err != nil {
+				p.logFor(ctx).Error("Error fetching latest release", "owner", owner, "repo", repo, "error", err)
+				res.Error = err.Error()
+				return
+			}
+			res.Tag = release.TagName
+			if withAssets {
+				for _, asset := range release.Assets {
+					res.Assets = append(res.Assets, asset.Name)
+				}
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+
+	writeJSON(w, http.StatusOK, results)
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// requestLimiter bounds the number of requests served at once. Once every slot
+// is taken, up to maxQueued requests wait for one; the rest are turned away.
+// Its limits can be changed while requests hold slots, so a config reload
+// doesn't start the count afresh.
+type requestLimiter struct {
+	mu        sync.Mutex
+	limit     int64
+	maxQueued int64
+	freed     chan struct{} // closed and replaced when queued requests should look for a slot
+
+	inFlight atomic.Int64
+	queued   atomic.Int64
+}
+
+func newRequestLimiter(maxInFlight, maxQueued int) *requestLimiter {
+	return &requestLimiter{
+		limit:     int64(maxInFlight),
+		maxQueued: int64(maxQueued),
+		freed:     make(chan struct{}),
+	}
+}
+
+// acquire takes a slot, waiting in the queue if there is room. It returns false
+// if the queue is full or ctx is done before a slot frees up.
+func (l *requestLimiter) acquire(ctx context.Context) bool {
+	l.mu.Lock()
+	if l.inFlight.Load() < l.limit {
+		l.inFlight.Add(1)
+		l.mu.Unlock()
+		return true
+	}
+	if l.queued.Load() >= l.maxQueued {
+		l.mu.Unlock()
+		return false
+	}
+	l.queued.Add(1)
+	for {
+		freed := l.freed
+		l.mu.Unlock()
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			l.queued.Add(-1)
+			return false
+		}
+		l.mu.Lock()
+		if l.inFlight.Load() < l.limit {
+			l.queued.Add(-1)
+			l.inFlight.Add(1)
+			l.mu.Unlock()
+			return true
+		}
+	}
+}
+
+func (l *requestLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight.Add(-1)
+	l.wake()
+}
+
+// resize changes the limits. Requests already over a lowered limit carry on;
+// new ones wait until enough of them finish.
+func (l *requestLimiter) resize(maxInFlight, maxQueued int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = int64(maxInFlight)
+	l.maxQueued = int64(maxQueued)
+	l.wake()
+}
+
+// wake lets queued requests check for a free slot. The caller holds l.mu.
+func (l *requestLimiter) wake() {
+	if l.queued.Load() > 0 {
+		close(l.freed)
+		l.freed = make(chan struct{})
+	}
+}
+
+// releasingBody is a response body that gives back its limiter slot when
+// closed, so a slot is held for as long as the body is being read.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// errAssetTooLarge is returned for assets bigger than maxAssetSize.
+var errAssetTooLarge = errors.New("asset exceeds maxAssetSize")
+
+// cappedBody is a response body that fails with errAssetTooLarge once more
+// than max bytes have been read from it, for upstreams that don't declare a
+// length or send more than they declared.
+type cappedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+// capBody enforces maxAssetSize on resp: errAssetTooLarge when its declared
+// length is already over, otherwise its body is wrapped in a cappedBody.
+func (p *GithubPrivateReleaseProxy) capBody(resp *http.Response) error {
+	if p.maxAssetSize <= 0 {
+		return nil
+	}
+	if resp.ContentLength > p.maxAssetSize {
+		return errAssetTooLarge
+	}
+	resp.Body = &cappedBody{ReadCloser: resp.Body, remaining: p.maxAssetSize}
+	return nil
+}
+
+func (b *cappedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errAssetTooLarge
+	}
+	// Read one byte past the cap so an asset of exactly max bytes still reads to EOF
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		// Hold back the byte that gave it away
+		return n - 1, errAssetTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRequestLimiterResize(t *testing.T) {
+	l := newRequestLimiter(1, 1)
+	ctx := context.Background()
+	if !l.acquire(ctx) {
+		t.Fatal("first acquire failed")
+	}
+
+	got := make(chan bool)
+	go func() { got <- l.acquire(ctx) }()
+	waitFor(t, "a request to queue", func() bool { return l.queued.Load() == 1 })
+
+	// The queue is full, so a third request is turned away
+	if l.acquire(ctx) {
+		t.Fatal("acquire succeeded with the slot taken and the queue full")
+	}
+
+	// Raising the limit lets the queued request in without waiting for a release
+	l.resize(2, 1)
+	select {
+	case ok := <-got:
+		if !ok {
+			t.Fatal("queued acquire failed after the limit was raised")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued request still waiting after the limit was raised")
+	}
+
+	// Lowering it holds new requests back until enough running ones finish
+	l.resize(1, 1)
+	go func() { got <- l.acquire(ctx) }()
+	waitFor(t, "a request to queue", func() bool { return l.queued.Load() == 1 })
+	l.release()
+	select {
+	case <-got:
+		t.Fatal("acquire succeeded with 1 of 1 slots still in use")
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.release()
+	if ok := <-got; !ok {
+		t.Fatal("queued acquire failed once a slot freed up")
+	}
+	if n := l.inFlight.Load(); n != 1 {
+		t.Errorf("in flight = %d, want 1", n)
+	}
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// proxyMetrics holds the Prometheus collectors exported on /metrics. A nil
+// *proxyMetrics records nothing, so call sites don't check whether metrics
+// are enabled.
+type proxyMetrics struct {
+	registry *prometheus.Registry
+
+	requests        prometheus.Counter
+	responses       *prometheus.CounterVec
+	assetBytes      prometheus.Counter
+	upstreamLatency *prometheus.HistogramVec
+	upstreamErrors  *prometheus.CounterVec
+	tokenCache      *prometheus.CounterVec
+}
+
+func newProxyMetrics(limiter *requestLimiter) *proxyMetrics {
+	m := &proxyMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "pkl_proxy_requests_total",
+			Help: "Requests received by the proxy.",
+		}),
+		responses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pkl_proxy_responses_total",
+			Help: "Responses sent by the proxy, by status code.",
+		}, []string{"code"}),
+		assetBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "pkl_proxy_asset_bytes_served_total",
+			Help: "Bytes of release assets streamed to clients.",
+		}),
+		upstreamLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "pkl_proxy_upstream_request_duration_seconds",
+			Help:    "Latency of GitHub requests, by call (release metadata or asset).",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"call"}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pkl_proxy_upstream_errors_total",
+			Help: "GitHub requests that failed or returned an unexpected status, by call.",
+		}, []string{"call"}),
+		tokenCache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pkl_proxy_token_cache_total",
+			Help: "Installation token source lookups, by result (hit or miss).",
+		}, []string{"result"}),
+	}
+	m.registry.MustRegister(
+		m.requests, m.responses, m.assetBytes, m.upstreamLatency, m.upstreamErrors, m.tokenCache,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "pkl_proxy_requests_in_flight",
+			Help: "Requests currently being served, not counting /livez, /healthz and /metrics.",
+		}, func() float64 { return float64(limiter.inFlight.Load()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "pkl_proxy_requests_queued",
+			Help: "Requests waiting for a slot under maxInFlightRequests.",
+		}, func() float64 { return float64(limiter.queued.Load()) }),
+	)
+	return m
+}
+
+func (m *proxyMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *proxyMetrics) request() {
+	if m != nil {
+		m.requests.Inc()
+	}
+}
+
+func (m *proxyMetrics) response(code int) {
+	if m != nil {
+		m.responses.WithLabelValues(strconv.Itoa(code)).Inc()
+	}
+}
+
+func (m *proxyMetrics) servedBytes(n int64) {
+	if m != nil {
+		m.assetBytes.Add(float64(n))
+	}
+}
+
+// upstream records the latency of a GitHub call started at start, and whether it failed.
+func (m *proxyMetrics) upstream(call string, start time.Time, failed bool) {
+	if m == nil {
+		return
+	}
+	m.upstreamLatency.WithLabelValues(call).Observe(time.Since(start).Seconds())
+	if failed {
+		m.upstreamErrors.WithLabelValues(call).Inc()
+	}
+}
+
+func (m *proxyMetrics) tokenCacheLookup(hit bool) {
+	if m == nil {
+		return
+	}
+	if hit {
+		m.tokenCache.WithLabelValues("hit").Inc()
+	} else {
+		m.tokenCache.WithLabelValues("miss").Inc()
+	}
+}
+
+// statusRecorder remembers the status code and body size written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// archiveFormat is one way a release may package the same payload, e.g.
+// "tool.tar.gz" next to "tool.zip".
+type archiveFormat struct {
+	name       string   // value of the ?format= query parameter
+	exts       []string // asset name suffixes in this format
+	mediaTypes []string // Accept media types that ask for it
+}
+
+// archiveFormats are in the order preferred when the client has no preference.
+var archiveFormats = []archiveFormat{
+	{"tar.gz", []string{".tar.gz", ".tgz"}, []string{"application/gzip", "application/x-gzip", "application/x-tar+gzip"}},
+	{"zip", []string{".zip"}, []string{"application/zip", "application/x-zip-compressed"}},
+	{"tar.xz", []string{".tar.xz", ".txz"}, []string{"application/x-xz"}},
+	{"tar", []string{".tar"}, []string{"application/x-tar"}},
+}
+
+// errNotAcceptable is returned by negotiateArchive when the release has the
+// payload, but in none of the formats the client asked for.
+type errNotAcceptable struct {
+	available []string
+}
+
+func (e *errNotAcceptable) Error() string {
+	return "requested format not available; release has " + strings.Join(e.available, ", ")
+}
+
+// negotiateArchive looks for assets named base plus an archive extension and
+// picks the one in the format r asks for: ?format= if given, else the Accept
+// header, else the first of archiveFormats present. It returns nil if the
+// release has no such asset.
+func negotiateArchive(files []Asset, base string, r *http.Request) (*Asset, error) {
+	candidates := make(map[string]*Asset)
+	var available []string
+	for _, format := range archiveFormats {
+		for _, ext := range format.exts {
+			for i := range files {
+				if files[i].Name == base+ext && candidates[format.name] == nil {
+					candidates[format.name] = &files[i]
+					available = append(available, files[i].Name)
+				}
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	// With no stated preference (or only unrelated media types), any will do
+	wants := requestedFormats(r)
+	if len(wants) == 0 {
+		wants = []string{"*"}
+	}
+	for _, want := range wants {
+		if want != "*" {
+			if asset := candidates[want]; asset != nil {
+				return asset, nil
+			}
+			continue
+		}
+		for _, format := range archiveFormats {
+			if asset := candidates[format.name]; asset != nil {
+				return asset, nil
+			}
+		}
+	}
+	return nil, &errNotAcceptable{available: available}
+}
+
+// requestedFormats lists the archive formats r asks for, most wanted first. A
+// ?format= parameter overrides Accept; "*" stands for any format.
+func requestedFormats(r *http.Request) []string {
+	if f := r.URL.Query().Get("format"); f != "" {
+		if f == "tgz" {
+			f = "tar.gz"
+		}
+		return []string{f}
+	}
+	var formats []string
+	for _, mediaType := range parseAccept(r.Header.Get("Accept")) {
+		if mediaType == "*/*" || mediaType == "application/*" {
+			formats = append(formats, "*")
+			continue
+		}
+		for _, format := range archiveFormats {
+			for _, mt := range format.mediaTypes {
+				if mt == mediaType {
+					formats = append(formats, format.name)
+				}
+			}
+		}
+	}
+	return formats
+}
+
+// parseAccept returns the media types of an Accept header ordered by quality,
+// highest first, leaving out those with q=0.
+func parseAccept(accept string) []string {
+	type entry struct {
+		mediaType string
+		q         float64
+	}
+	var entries []entry
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		if mediaType == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			entries = append(entries, entry{mediaType, q})
+		}
+	}
+	// Stable, so equally weighted types keep the client's order
+	slices.SortStableFunc(entries, func(a, b entry) int { return cmp.Compare(b.q, a.q) })
+	types := make([]string, len(entries))
+	for i, e := range entries {
+		types[i] = e.mediaType
+	}
+	return types
+}
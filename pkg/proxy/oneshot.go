@@ -0,0 +1,35 @@
+package proxy
+
+import "sync"
+
+// Oneshot limits the proxy to a single successfully served asset and records
+// what it was, for "pkl-proxy run --oneshot".
+type Oneshot struct {
+	mu    sync.Mutex
+	done  bool
+	path  string
+	bytes int64
+}
+
+// record notes a successfully served asset. Only the first one is kept.
+func (o *Oneshot) record(path string, bytes int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.done {
+		return
+	}
+	o.done, o.path, o.bytes = true, path, bytes
+}
+
+// Served returns the asset that was served, if any.
+func (o *Oneshot) Served() (path string, bytes int64, ok bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.path, o.bytes, o.done
+}
+
+// EnableOneshot makes the proxy refuse requests once one asset has been served.
+func (p *GithubPrivateReleaseProxy) EnableOneshot() *Oneshot {
+	p.oneshot = &Oneshot{}
+	return p.oneshot
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// progressStep is how many bytes of a large asset are copied between progress
+// log lines.
+const progressStep = 10 << 20
+
+// progressWriter counts the bytes written through it and logs the running total
+// and transfer rate at most once per interval, and each time another step bytes
+// have been written. A zero interval or step disables that trigger.
+type progressWriter struct {
+	w        io.Writer
+	log      *slog.Logger
+	level    slog.Level
+	interval time.Duration
+	step     int64
+
+	written  int64
+	nextStep int64
+	start    time.Time
+	last     time.Time
+}
+
+func newProgressWriter(w io.Writer, log *slog.Logger, level slog.Level, interval time.Duration, step int64) *progressWriter {
+	now := time.Now()
+	return &progressWriter{
+		w:        w,
+		log:      log,
+		level:    level,
+		interval: interval,
+		step:     step,
+		nextStep: step,
+		start:    now,
+		last:     now,
+	}
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.written += int64(n)
+
+	now := time.Now()
+	byTime := pw.interval > 0 && now.Sub(pw.last) >= pw.interval
+	bySize := pw.step > 0 && pw.written >= pw.nextStep
+	if byTime || bySize {
+		pw.last = now
+		for pw.step > 0 && pw.nextStep <= pw.written {
+			pw.nextStep += pw.step
+		}
+		pw.log.Log(context.Background(), pw.level, "Transfer progress",
+			"bytes", pw.written,
+			"bytesPerSecond", pw.rate(now))
+	}
+	return n, err
+}
+
+// finish logs the total bytes written and how long the transfer took. err is
+// the copy's error, if it ended early.
+func (pw *progressWriter) finish(err error) {
+	now := time.Now()
+	attrs := []any{
+		"bytes", pw.written,
+		"duration", now.Sub(pw.start),
+		"bytesPerSecond", pw.rate(now),
+	}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	pw.log.Log(context.Background(), pw.level, "Transfer finished", attrs...)
+}
+
+func (pw *progressWriter) rate(now time.Time) int64 {
+	elapsed := now.Sub(pw.start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(pw.written) / elapsed)
+}
+
+// contextReader fails reads once ctx is done. Copying through it ends a
+// transfer at the next chunk after the client goes away, rather than when
+// the upstream body or the client connection happens to notice.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(b []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(b)
+}
+
+// copyBuffers holds the buffers copyContext copies through, so a download
+// doesn't allocate one of its own.
+var copyBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 32<<10)
+		return &buf
+	},
+}
+
+// copyContext copies src to dst until src is exhausted, a write fails, or ctx
+// is done.
+func copyContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	return io.CopyBuffer(dst, contextReader{ctx: ctx, r: src}, *buf)
+}
@@ -0,0 +1,1154 @@
+// Package proxy serves assets of private GitHub releases over plain HTTP, so
+// pkl can fetch packages published to private repos. It holds the handler,
+// the token manager that authenticates it to GitHub, and config loading;
+// the pkl-proxy command is a CLI around it.
+//
+// To run the proxy in-process, for example behind an httptest.Server:
+//
+//	config, err := proxy.LoadConfig(configDir)
+//	...
+//	privateKey, err := proxy.ReadPrivateKey(configDir, config) // if proxy.UsesApp(config)
+//	...
+//	tm, err := proxy.NewTokenManager(config, configDir, privateKey, proxy.NewUpstreamClient(config))
+//	...
+//	srv := httptest.NewServer(proxy.NewGithubPrivateReleaseProxy(tm, config))
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bmurray/pkl-proxy/gen/appconfig"
+)
+
+type repoContextKey struct{}
+
+type repoInfo struct {
+	Owner string
+	Repo  string
+}
+
+func withRepo(ctx context.Context, owner, repo string) context.Context {
+	return context.WithValue(ctx, repoContextKey{}, repoInfo{Owner: owner, Repo: repo})
+}
+
+type requestIDContextKey struct{}
+
+// withRequestID tags ctx with the ID ServeHTTP gave its request.
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+// newRequestID returns a short random ID for correlating a request's log lines.
+func newRequestID() string {
+	var b [6]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// logFor returns the proxy's logger, annotated with the ID of the request ctx
+// belongs to when there is one.
+func (p *GithubPrivateReleaseProxy) logFor(ctx context.Context) *slog.Logger {
+	if id, ok := ctx.Value(requestIDContextKey{}).(string); ok {
+		return p.log.With("requestId", id)
+	}
+	return p.log
+}
+
+func repoFromContext(ctx context.Context) (owner, repo string, ok bool) {
+	ri, ok := ctx.Value(repoContextKey{}).(repoInfo)
+	if !ok {
+		return "", "", false
+	}
+	return ri.Owner, ri.Repo, true
+}
+
+// GithubPrivateReleaseProxy is the http.Handler that serves release assets,
+// source archives and repo files, authenticating to GitHub through a
+// TokenManager.
+type GithubPrivateReleaseProxy struct {
+	client   *http.Client
+	tokens   *TokenManager
+	handler  http.Handler
+	limiter  *requestLimiter // bounds requests served at once; unlimited without maxInFlightRequests
+	upstream *requestLimiter // bounds concurrent GitHub requests; queues without limit
+	metrics  *proxyMetrics   // nil unless metrics are enabled
+	log      *slog.Logger
+
+	digests  *digestCache
+	releases *releaseCache // nil when releaseCacheTTL is zero
+	oneshot  *Oneshot      // nil unless running with run --oneshot
+	streams  *atomic.Int64 // asset bodies currently being copied to clients
+
+	authToken          string   // bearer token clients must present; empty disables the check
+	allowedRepos       []string // owner/repo patterns served; empty allows all
+	includeDrafts      bool
+	probeAssetSize     bool
+	maxReleasesScanned int
+	duplicateAssets    string
+	verifyChecksums    bool
+	assetGlobs         bool
+	primaryAsset       string            // name template for the tag-only route fallback; empty disables it
+	assetTemplates     map[string]string // requested file name to asset name template
+	progressInterval   time.Duration     // zero disables progress logging
+	progressThreshold  int64             // assets at least this big log progress by size; zero disables it
+	apiTimeout         time.Duration     // bounds a whole metadata call, body included
+	progressLevel      slog.Level
+	maxAssetSize       int64 // zero means unlimited
+}
+
+// NewGithubPrivateReleaseProxy returns a proxy configured by config that gets
+// its GitHub credentials from tm.
+func NewGithubPrivateReleaseProxy(tm *TokenManager, config *appconfig.AppConfig) *GithubPrivateReleaseProxy {
+	return newProxy(tm, config, nil)
+}
+
+// Reconfigured returns a proxy built from config that carries on from p: it
+// shares p's token manager, caches, limiters, download count and metrics, so
+// applying a reloaded config drops no tokens and resets no counts.
+func (p *GithubPrivateReleaseProxy) Reconfigured(config *appconfig.AppConfig) *GithubPrivateReleaseProxy {
+	return newProxy(p.tokens, config, p)
+}
+
+// newProxy builds a proxy from config. With prev set, the state that has to
+// outlive a reload is taken over from prev rather than created.
+func newProxy(tm *TokenManager, config *appconfig.AppConfig, prev *GithubPrivateReleaseProxy) *GithubPrivateReleaseProxy {
+	client := &http.Client{
+		Transport:     &GithubTripper{tm: tm, base: tm.client.Transport},
+		CheckRedirect: checkRedirect(config.AllowedRedirectHosts),
+	}
+	prox := &GithubPrivateReleaseProxy{
+		client:             client,
+		tokens:             tm,
+		log:                slog.Default().With("component", "GithubPrivateReleaseProxy"),
+		includeDrafts:      config.IncludeDrafts,
+		probeAssetSize:     config.ProbeAssetSize,
+		maxReleasesScanned: config.MaxReleasesScanned,
+		duplicateAssets:    config.DuplicateAssets,
+		verifyChecksums:    config.VerifyChecksums,
+		assetGlobs:         config.AssetGlobs,
+		assetTemplates:     config.AssetNameTemplates,
+		allowedRepos:       config.AllowedRepos,
+		apiTimeout:         DurationOr(&config.UpstreamTimeout, defaultUpstreamTimeout),
+	}
+	if config.ProxyAuthToken != nil {
+		prox.authToken = *config.ProxyAuthToken
+	}
+	if config.PrimaryAssetTemplate != nil {
+		prox.primaryAsset = *config.PrimaryAssetTemplate
+	}
+	if config.ProgressInterval != nil {
+		prox.progressInterval = DurationOr(config.ProgressInterval, 0)
+	}
+	if config.ProgressThreshold != nil {
+		prox.progressThreshold = int64(*config.ProgressThreshold)
+	}
+	prox.progressLevel.UnmarshalText([]byte(config.ProgressLogLevel))
+	if config.MaxAssetSize != nil {
+		prox.maxAssetSize = int64(*config.MaxAssetSize)
+	}
+
+	maxInFlight, maxQueued := math.MaxInt, 0
+	if config.MaxInFlightRequests != nil {
+		maxInFlight, maxQueued = *config.MaxInFlightRequests, config.MaxQueuedRequests
+	}
+	releaseCacheTTL := DurationOr(&config.ReleaseCacheTTL, 0)
+	if prev == nil {
+		prox.digests = newDigestCache()
+		prox.releases = newReleaseCache(releaseCacheTTL)
+		prox.streams = new(atomic.Int64)
+		prox.limiter = newRequestLimiter(maxInFlight, maxQueued)
+		prox.upstream = newRequestLimiter(config.MaxConcurrentUpstream, math.MaxInt)
+	} else {
+		prox.digests = prev.digests
+		prox.releases = prev.releases
+		if prev.releases == nil || prev.releases.ttl != releaseCacheTTL {
+			prox.releases = newReleaseCache(releaseCacheTTL)
+		}
+		prox.streams = prev.streams
+		prox.limiter = prev.limiter
+		prox.limiter.resize(maxInFlight, maxQueued)
+		prox.upstream = prev.upstream
+		prox.upstream.resize(config.MaxConcurrentUpstream, math.MaxInt)
+		prox.metrics = prev.metrics
+	}
+	switch {
+	case !config.Metrics:
+		prox.metrics = nil
+	case prox.metrics == nil:
+		prox.metrics = newProxyMetrics(prox.limiter)
+	}
+	tm.metrics.Store(prox.metrics)
+
+	// GET patterns also match HEAD; any other method gets a 405 with an
+	// Allow header from the mux.
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{user}/{repo}/{path...}", prox.releaseHandler)
+	mux.HandleFunc("GET /{user}/{repo}/zipball/{ref...}", prox.archiveHandler("zipball"))
+	mux.HandleFunc("GET /{user}/{repo}/tarball/{ref...}", prox.archiveHandler("tarball"))
+	mux.HandleFunc("GET /{user}/{repo}/contents/{tag}/{path...}", prox.contentsHandler)
+	mux.HandleFunc("GET /releases/latest", prox.latestReleasesHandler)
+	mux.HandleFunc("GET /livez", prox.livenessHandler)
+	mux.HandleFunc("GET /healthz", prox.readinessHandler)
+	if prox.metrics != nil {
+		mux.Handle("GET /metrics", prox.metrics.handler())
+	}
+	mux.HandleFunc("GET /{$}", prox.rootHandler)
+	mux.HandleFunc("GET /", prox.notFoundHandler)
+	prox.handler = mux
+	return prox
+}
+
+func (p *GithubPrivateReleaseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	p.metrics.request()
+	id := newRequestID()
+	r = r.WithContext(withRequestID(r.Context(), id))
+	w.Header().Set("X-Request-Id", id)
+	log := p.logFor(r.Context())
+	rec := &statusRecorder{ResponseWriter: w}
+	defer func() {
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		p.metrics.response(rec.status)
+		log.Info("Request completed", "method", r.Method, "url", r.URL.String(),
+			"status", rec.status, "bytes", rec.bytes, "duration", time.Since(start))
+	}()
+	w = rec
+	if p.authToken != "" && !probePaths[r.URL.Path] && !p.authorized(r) {
+		log.Warn("Rejecting unauthenticated request", "url", r.URL.String())
+		w.Header().Set("WWW-Authenticate", `Bearer realm="pkl-proxy"`)
+		http.Error(w, "Missing or invalid proxy token", http.StatusUnauthorized)
+		return
+	}
+	if owner, repo, ok := repoFromPath(r.URL); ok && !p.repoAllowed(owner, repo) {
+		log.Warn("Rejecting request for repo outside allowedRepos", "owner", owner, "repo", repo)
+		http.Error(w, fmt.Sprintf("Repo %s/%s is not served by this proxy", owner, repo), http.StatusForbidden)
+		return
+	}
+	if monitoringPaths[r.URL.Path] {
+		p.handler.ServeHTTP(w, r)
+		return
+	}
+	if !p.limiter.acquire(r.Context()) {
+		log.Warn("Rejecting request, proxy is at capacity", "url", r.URL.String())
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Proxy is at capacity, retry shortly", http.StatusServiceUnavailable)
+		return
+	}
+	defer p.limiter.release()
+	if p.oneshot != nil {
+		if _, _, done := p.oneshot.Served(); done {
+			http.Error(w, "This oneshot proxy has already served its request", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	p.handler.ServeHTTP(w, r)
+}
+
+// Version is reported by the root handler. The CLI sets it to its build version.
+var Version = "devel"
+
+// routes lists the request path shapes the proxy serves, as reported by the root handler.
+var routes = []string{
+	"/{owner}/{repo}/{tag}",
+	"/{owner}/{repo}/{tag...}/{file}",
+	"/{owner}/{repo}/{tag...}/",
+	"/{owner}/{repo}/releases/download/{tag...}/{file}",
+	"/{owner}/{repo}/zipball/{ref...}",
+	"/{owner}/{repo}/tarball/{ref...}",
+	"/{owner}/{repo}/contents/{ref}/{path...}",
+	"/releases/latest?repo={owner}/{repo}",
+	"/livez",
+	"/healthz",
+}
+
+// rootHandler describes the service to anyone who hits "/" directly.
+func (p *GithubPrivateReleaseProxy) rootHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"name":    "pkl-proxy",
+		"version": Version,
+		"routes":  routes,
+	})
+}
+
+// notFoundHandler returns a consistent JSON 404 for paths that match no route.
+func (p *GithubPrivateReleaseProxy) notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusNotFound, map[string]any{
+		"error":  "no route matches " + r.URL.Path,
+		"routes": routes,
+	})
+}
+
+// authorized reports whether r carries the configured proxyAuthToken as a bearer token.
+func (p *GithubPrivateReleaseProxy) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(p.authToken)) == 1
+}
+
+// ActiveStreams is the number of asset downloads in progress.
+func (p *GithubPrivateReleaseProxy) ActiveStreams() int64 {
+	return p.streams.Load()
+}
+
+// upstreamError responds to a failed GitHub call: 429 with Retry-After when
+// GitHub rate limited us, so clients can back off, and 500 otherwise.
+func (p *GithubPrivateReleaseProxy) upstreamError(ctx context.Context, w http.ResponseWriter, msg string, err error) {
+	var limited *rateLimitError
+	if errors.As(err, &limited) {
+		p.logFor(ctx).Warn("GitHub rate limit exceeded", "reset", limited.reset)
+		w.Header().Set("Retry-After", limited.retryAfter())
+		http.Error(w, msg+": "+err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	p.logFor(ctx).Error(msg, "error", err)
+	http.Error(w, msg+": "+err.Error(), http.StatusInternalServerError)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// releaseHandler splits the path after /{user}/{repo}/ into a tag and a file so
+// that tags containing slashes (e.g. "release/1.2.3") can be served. The rules:
+//
+//   - A leading "releases/download/" (GitHub's download URL shape) is dropped.
+//   - A single segment is a tag, served by taggedHandler.
+//   - A trailing slash after the tag asks for the release's asset list.
+//   - Otherwise the last segment is the file and everything before it is the tag.
+//
+// Asset names can't contain slashes, so the last segment is never part of the tag.
+func (p *GithubPrivateReleaseProxy) releaseHandler(w http.ResponseWriter, r *http.Request) {
+	path := r.PathValue("path")
+	if rest, ok := strings.CutPrefix(path, "releases/download/"); ok && strings.Contains(rest, "/") {
+		path = rest
+	}
+
+	tag, file, hasFile := cutLast(path, "/")
+	if !hasFile {
+		tag = path
+	}
+	if tag == "" {
+		p.notFoundHandler(w, r)
+		return
+	}
+
+	r.SetPathValue("tag", tag)
+	if hasFile && file == "" {
+		p.indexHandler(w, r)
+		return
+	}
+	if !hasFile {
+		p.taggedHandler(w, r)
+		return
+	}
+	r.SetPathValue("file", file)
+	p.taggedFileHandler(w, r)
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// taggedHandler serves the release's "primary" asset for /{owner}/{repo}/{tag}.
+// Pkl packages are published as a release whose tag is the package name plus
+// version and whose metadata asset carries the same name (e.g. tag and asset
+// "mylib@1.2.3"), so the asset named after the tag is the one a package URI
+// points at. Repos with another convention can name the asset with
+// primaryAssetTemplate. A release with neither gets a 404.
+func (p *GithubPrivateReleaseProxy) taggedHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	repo := r.PathValue("repo")
+	tag := r.PathValue("tag")
+
+	p.logFor(r.Context()).Debug("Handling request for GitHub release", "user", user, "repo", repo, "tag", tag)
+
+	ctx := withRepo(r.Context(), user, repo)
+	files, err := p.files(ctx, user, repo, tag)
+	if errors.Is(err, ErrNotFound) {
+		releaseNotFound(w, tag)
+		return
+	}
+	if err != nil {
+		p.upstreamError(ctx, w, "Error fetching release files", err)
+		return
+	}
+	asset, err := p.findAsset(files, tag)
+	if err == nil && asset == nil && p.primaryAsset != "" {
+		// No asset named after the tag; fall back to the repo's conventional primary artifact
+		asset, err = p.findAsset(files, primaryAssetName(p.primaryAsset, repo, tag))
+	}
+	if err != nil {
+		p.logFor(ctx).Error("Error matching release asset", "error", err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if asset == nil {
+		msg := fmt.Sprintf("Release %q has no asset named %q", tag, tag)
+		if p.primaryAsset != "" {
+			msg += fmt.Sprintf(" or %q", primaryAssetName(p.primaryAsset, repo, tag))
+		}
+		http.Error(w, msg, http.StatusNotFound)
+		return
+	}
+	checksum, err := p.checksumFor(files, asset)
+	if err != nil {
+		p.logFor(ctx).Error("Error matching checksum asset", "error", err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	p.serveAsset(ctx, w, r, asset, checksum)
+}
+
+func (p *GithubPrivateReleaseProxy) taggedFileHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	repo := r.PathValue("repo")
+	tag := r.PathValue("tag")
+	file := r.PathValue("file")
+	p.logFor(r.Context()).Debug("Handling request for GitHub release asset", "user", user, "repo", repo, "tag", tag, "file", file)
+
+	ctx := withRepo(r.Context(), user, repo)
+	release, err := p.taggedRelease(ctx, user, repo, tag)
+	if errors.Is(err, ErrNotFound) {
+		releaseNotFound(w, tag)
+		return
+	}
+	if err != nil {
+		p.upstreamError(ctx, w, "Error fetching release files", err)
+		return
+	}
+	files := release.Assets
+	if template, ok := p.assetTemplates[file]; ok {
+		name, err := expandAssetName(template, repo, release.TagName, r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Asset %q: %v", file, err), http.StatusBadRequest)
+			return
+		}
+		p.logFor(ctx).Debug("Expanded asset name template", "file", file, "asset", name)
+		file = name
+	}
+	asset, err := p.findAsset(files, file)
+	if err == nil && asset == nil && p.assetGlobs {
+		asset, err = matchAsset(files, file)
+	}
+	if err == nil && asset == nil {
+		// "tool" may be published as "tool.tar.gz" and "tool.zip"; serve the one the client wants
+		asset, err = negotiateArchive(files, file, r)
+		if asset != nil {
+			w.Header().Add("Vary", "Accept")
+		}
+	}
+	var notAcceptable *errNotAcceptable
+	if errors.As(err, &notAcceptable) {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
+	if err != nil {
+		p.logFor(ctx).Error("Error matching release asset", "error", err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if asset == nil {
+		// "<asset>.sha256" is computed on the fly when the release doesn't publish one.
+		if name, ok := strings.CutSuffix(file, ".sha256"); ok {
+			if base, err := p.findAsset(files, name); err == nil && base != nil {
+				p.serveDigest(ctx, w, base)
+				return
+			}
+		}
+		http.Error(w, "File not found in release assets", http.StatusNotFound)
+		return
+	}
+	checksum, err := p.checksumFor(files, asset)
+	if err != nil {
+		p.logFor(ctx).Error("Error matching checksum asset", "error", err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	p.serveAsset(ctx, w, r, asset, checksum)
+}
+
+// assetTooLarge answers a request for an asset bigger than maxAssetSize. size
+// is negative when the asset's real size isn't known.
+func assetTooLarge(w http.ResponseWriter, size, max int64) {
+	msg := fmt.Sprintf("Asset is over the %d byte maxAssetSize", max)
+	if size >= 0 {
+		msg = fmt.Sprintf("Asset is %d bytes, over the %d byte maxAssetSize", size, max)
+	}
+	http.Error(w, msg, http.StatusRequestEntityTooLarge)
+}
+
+// releaseNotFound answers a request for a release GitHub doesn't know about (or
+// doesn't let us see), so clients can tell a bad tag from a proxy failure.
+func releaseNotFound(w http.ResponseWriter, tag string) {
+	if tag == latestTag {
+		http.Error(w, "Repository has no published release", http.StatusNotFound)
+		return
+	}
+	http.Error(w, fmt.Sprintf("Release %q not found", tag), http.StatusNotFound)
+}
+
+// serveAsset streams asset to the client. The upstream ETag is passed through,
+// and a client If-None-Match that matches it, or an If-Modified-Since no older
+// than the asset, gets a 304. When checksum is non-nil the asset is verified
+// against it before anything is sent. An ?accept= query parameter asks GitHub
+// for another of assetMediaTypes, such as the asset's JSON metadata, which is
+// passed through unverified.
+func (p *GithubPrivateReleaseProxy) serveAsset(ctx context.Context, w http.ResponseWriter, r *http.Request, asset, checksum *Asset) {
+	p.logFor(ctx).Debug("Found matching file for tag", "file", asset.Name, "url", asset.BrowserDownloadURL)
+
+	accept := mediaTypeBinary
+	if v := r.URL.Query().Get("accept"); v != "" {
+		if !slices.Contains(assetMediaTypes, v) {
+			http.Error(w, fmt.Sprintf("Unsupported accept %q; use one of %s", v, strings.Join(assetMediaTypes, ", ")), http.StatusBadRequest)
+			return
+		}
+		accept = v
+	}
+	binary := accept == mediaTypeBinary
+	if !binary {
+		checksum = nil
+	}
+
+	// Re-uploading an asset bumps its updated_at, so the release metadata is
+	// enough to answer If-Modified-Since without asking GitHub for the asset.
+	// If-None-Match takes precedence when both are sent (RFC 9110 13.1.3).
+	if r.Header.Get("If-None-Match") == "" && notModifiedSince(r.Header.Get("If-Modified-Since"), asset.UpdatedAt) {
+		w.Header().Set("Last-Modified", asset.UpdatedAt.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if binary && p.maxAssetSize > 0 && asset.Size > p.maxAssetSize {
+		assetTooLarge(w, asset.Size, p.maxAssetSize)
+		return
+	}
+
+	// The release metadata only describes the asset bytes
+	if r.Method == http.MethodHead && binary {
+		p.serveAssetHead(ctx, w, asset)
+		return
+	}
+
+	// Conditional and range headers go upstream so resumed downloads only fetch
+	// what's missing. Only whole assets can be verified, so ranges are dropped
+	// when checking a checksum.
+	forward := []string{"If-None-Match", "If-Modified-Since", "Range", "If-Range"}
+	if checksum != nil {
+		forward = forward[:2]
+	}
+	extra := http.Header{"Accept": {accept}}
+	for _, name := range forward {
+		if v := r.Header.Get(name); v != "" {
+			extra.Set(name, v)
+		}
+	}
+	// The checksum is fetched first so that it never waits for an upstream slot
+	// while the asset download is holding one
+	var want string
+	if checksum != nil {
+		var err error
+		if want, err = p.expectedDigest(ctx, checksum); err != nil {
+			p.logFor(ctx).Error("Could not fetch asset checksum", "file", checksum.Name, "error", err)
+			http.Error(w, "Asset failed checksum verification: fetching checksum: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+	resp, err := p.file(ctx, asset, extra)
+	if errors.Is(err, errAssetTooLarge) {
+		assetTooLarge(w, resp.ContentLength, p.maxAssetSize)
+		return
+	}
+	if err != nil {
+		p.upstreamError(ctx, w, "Error fetching file content", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	copyAssetHeaders(w.Header(), resp.Header)
+	// The storage host usually labels everything application/octet-stream; the
+	// content type recorded on the release asset is more useful to clients.
+	if asset.ContentType != "" && binary {
+		w.Header().Set("Content-Type", asset.ContentType)
+	}
+	etag := resp.Header.Get("ETag")
+	// The storage host may ignore the conditions, so check them ourselves as well
+	notModified := etag != "" && etagMatches(r.Header.Get("If-None-Match"), etag)
+	if r.Header.Get("If-None-Match") == "" {
+		modified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+		notModified = notModifiedSince(r.Header.Get("If-Modified-Since"), modified)
+	}
+	if resp.StatusCode == http.StatusNotModified || notModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	var body io.Reader = resp.Body
+	if checksum != nil && resp.StatusCode == http.StatusOK {
+		spool, size, err := p.spoolVerified(ctx, resp.Body, checksum, want)
+		if errors.Is(err, errAssetTooLarge) {
+			assetTooLarge(w, -1, p.maxAssetSize)
+			return
+		}
+		if err != nil {
+			p.logFor(ctx).Error("Asset failed checksum verification", "file", asset.Name, "error", err)
+			http.Error(w, "Asset failed checksum verification: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer removeSpool(spool)
+		resp.Body.Close() // frees the upstream slot while the client reads the spool
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		body = spool
+	}
+
+	// 206 and 416 are passed on; an upstream that ignores Range answers 200 with
+	// the whole asset, which is passed on as is.
+	w.WriteHeader(resp.StatusCode)
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		return
+	}
+
+	// Small assets are copied straight to the client; only large ones, or all of
+	// them when progressInterval is set, go through a progressWriter
+	var dst io.Writer = w
+	var progress *progressWriter
+	large := p.progressThreshold > 0 && asset.Size >= p.progressThreshold
+	if p.progressInterval > 0 || large {
+		var step int64
+		if large {
+			step = progressStep
+		}
+		progress = newProgressWriter(w, p.logFor(ctx).With("file", asset.Name), p.progressLevel, p.progressInterval, step)
+		dst = progress
+	}
+	p.streams.Add(1)
+	n, err := copyContext(ctx, dst, body)
+	p.streams.Add(-1)
+	if large {
+		progress.finish(err)
+	}
+	if errors.Is(err, errAssetTooLarge) {
+		// Too late for a 413; abort the response so the client can't mistake
+		// the truncated body for the whole asset
+		p.logFor(ctx).Error("Asset exceeded maxAssetSize mid-stream", "file", asset.Name, "bytes", n, "maxAssetSize", p.maxAssetSize)
+		panic(http.ErrAbortHandler)
+	}
+	p.metrics.servedBytes(n)
+	if ctx.Err() != nil {
+		// Returning closes the upstream body, so GitHub stops sending too
+		p.logFor(ctx).Debug("Client cancelled download", "file", asset.Name, "bytes", n)
+	}
+	if err == nil && p.oneshot != nil {
+		p.oneshot.record(r.URL.Path, n)
+	}
+}
+
+// forwardedAssetHeaders are the only upstream response headers passed on to
+// clients. Everything else, notably cookies and anything credential-related
+// from the storage host, is dropped.
+var forwardedAssetHeaders = []string{
+	"Content-Type",
+	"Content-Encoding",
+	"Content-Length",
+	"ETag",
+	"Last-Modified",
+	"Accept-Ranges",
+	"Content-Range",
+}
+
+func copyAssetHeaders(dst, src http.Header) {
+	for _, name := range forwardedAssetHeaders {
+		if v := src.Get(name); v != "" {
+			dst.Set(name, v)
+		}
+	}
+}
+
+// serveAssetHead answers a HEAD request for asset without downloading it. The
+// length comes from the release metadata; if that lacks a size, it is probed
+// upstream only when probeAssetSize is set, and omitted otherwise.
+func (p *GithubPrivateReleaseProxy) serveAssetHead(ctx context.Context, w http.ResponseWriter, asset *Asset) {
+	size := asset.Size
+	if size <= 0 && p.probeAssetSize {
+		var err error
+		size, err = p.probeSize(ctx, asset)
+		if err != nil {
+			p.logFor(ctx).Warn("Could not determine asset size", "file", asset.Name, "error", err)
+		}
+	}
+	if size > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	if asset.ContentType != "" {
+		w.Header().Set("Content-Type", asset.ContentType)
+	}
+	if !asset.UpdatedAt.IsZero() {
+		w.Header().Set("Last-Modified", asset.UpdatedAt.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// probeSize discovers an asset's length with a one-byte range request, which
+// works against signed storage URLs that may reject HEAD.
+func (p *GithubPrivateReleaseProxy) probeSize(ctx context.Context, asset *Asset) (int64, error) {
+	resp, err := p.file(ctx, asset, http.Header{"Range": {"bytes=0-0"}})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusPartialContent {
+		_, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/")
+		if ok {
+			return strconv.ParseInt(total, 10, 64)
+		}
+	}
+	if resp.ContentLength >= 0 && resp.StatusCode == http.StatusOK {
+		return resp.ContentLength, nil
+	}
+	return 0, fmt.Errorf("upstream did not report a length")
+}
+
+// notModifiedSince reports whether an If-Modified-Since header value is at or
+// after modified. An unparseable header, or an unknown modification time, never
+// counts as a match.
+func notModifiedSince(ifModifiedSince string, modified time.Time) bool {
+	if ifModifiedSince == "" || modified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+	// HTTP dates have whole-second precision
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag,
+// using the weak comparison RFC 9110 specifies for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// primaryAssetName expands the {repo} and {tag} placeholders in a primaryAssetTemplate.
+func primaryAssetName(template, repo, tag string) string {
+	return strings.NewReplacer("{repo}", repo, "{tag}", tag).Replace(template)
+}
+
+// expandAssetName fills in an assetNameTemplates entry for a request. tag is the
+// release's own tag, so "latest" requests expand to a real version.
+func expandAssetName(template, repo, tag string, r *http.Request) (string, error) {
+	replacements := []string{
+		"{repo}", repo,
+		"{tag}", tag,
+		"{version}", strings.TrimPrefix(tag, "v"),
+	}
+	for _, param := range []string{"os", "arch"} {
+		placeholder := "{" + param + "}"
+		if !strings.Contains(template, placeholder) {
+			continue
+		}
+		header := "X-Pkl-Proxy-" + strings.ToUpper(param[:1]) + param[1:]
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			value = r.Header.Get(header)
+		}
+		if value == "" {
+			return "", fmt.Errorf("name needs %s; pass ?%s= or the %s header", param, param, header)
+		}
+		replacements = append(replacements, placeholder, value)
+	}
+	return strings.NewReplacer(replacements...).Replace(template), nil
+}
+
+// findAsset returns the asset called name, or nil if the release has none. When
+// several assets share the name, duplicateAssets decides between failing and
+// picking the most recently updated one.
+func (p *GithubPrivateReleaseProxy) findAsset(files []Asset, name string) (*Asset, error) {
+	var match *Asset
+	for i := range files {
+		f := &files[i]
+		if f.Name != name {
+			continue
+		}
+		if match == nil {
+			match = f
+			continue
+		}
+		if p.duplicateAssets != "newest" {
+			return nil, fmt.Errorf("release has more than one asset named %q", name)
+		}
+		if f.UpdatedAt.After(match.UpdatedAt) {
+			match = f
+		}
+	}
+	return match, nil
+}
+
+// matchAsset returns the one asset whose name matches the glob pattern, or nil
+// if none does. Several matches are an error naming them, since picking one
+// would silently depend on upload order.
+func matchAsset(files []Asset, pattern string) (*Asset, error) {
+	if !strings.ContainsAny(pattern, "*?[") {
+		return nil, nil
+	}
+	var matches []*Asset
+	for i := range files {
+		// path.Match, unlike filepath.Match, treats backslash as an escape on every OS
+		if ok, _ := path.Match(pattern, files[i].Name); ok {
+			matches = append(matches, &files[i])
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, nil
+	case 1:
+		return matches[0], nil
+	}
+	names := make([]string, len(matches))
+	for i, m := range matches {
+		names[i] = m.Name
+	}
+	return nil, fmt.Errorf("%q matches more than one asset: %s", pattern, strings.Join(names, ", "))
+}
+
+// latestTag is the tag alias that resolves to the repo's newest published release.
+// Like GitHub's own "latest", it never resolves to a prerelease or draft.
+const latestTag = "latest"
+
+func (p *GithubPrivateReleaseProxy) files(ctx context.Context, user, repo, tag string) ([]Asset, error) {
+	release, err := p.taggedRelease(ctx, user, repo, tag)
+	if err != nil {
+		return nil, err
+	}
+	return release.Assets, nil
+}
+
+// taggedRelease returns the release for tag, which may be latestTag.
+func (p *GithubPrivateReleaseProxy) taggedRelease(ctx context.Context, user, repo, tag string) (*Release, error) {
+	if tag == latestTag {
+		return p.release(ctx, user, repo, "latest")
+	}
+	release, err := p.release(ctx, user, repo, "tags", tag)
+	if errors.Is(err, ErrNotFound) && p.includeDrafts {
+		// Drafts have no tag yet, so they can only be found by listing releases.
+		release, err = p.draftRelease(ctx, user, repo, tag)
+	}
+	return release, err
+}
+
+// LatestRelease returns the newest published release of owner/repo, the one
+// the "latest" tag serves. The error wraps ErrNotFound when there is none.
+func (p *GithubPrivateReleaseProxy) LatestRelease(ctx context.Context, owner, repo string) (*Release, error) {
+	return p.release(withRepo(ctx, owner, repo), owner, repo, "latest")
+}
+
+// ErrNotFound is wrapped by API errors caused by a 404 from GitHub.
+var ErrNotFound = errors.New("not found")
+
+// errNotModified is returned by apiGet when GitHub answers a conditional request with 304.
+var errNotModified = errors.New("not modified")
+
+// release fetches a release from /repos/{user}/{repo}/releases/{path...}. GitHub
+// treats user and repo case-insensitively, so they are lowercased and share a
+// cache entry however a client spells them; tags are case-sensitive and kept.
+func (p *GithubPrivateReleaseProxy) release(ctx context.Context, user, repo string, path ...string) (*Release, error) {
+	ux, err := url.Parse("https://api.github.com/repos/")
+	if err != nil {
+		return nil, fmt.Errorf("error parsing base URL: %w", err)
+	}
+	ux = ux.JoinPath(strings.ToLower(user), strings.ToLower(repo), "releases").JoinPath(path...)
+
+	key := ux.String()
+	cached, ok := p.releases.get(key)
+	var extra http.Header
+	if ok {
+		extra = http.Header{"If-None-Match": {cached.etag}}
+	}
+
+	release := Release{}
+	header, err := p.apiGet(ctx, key, &release, extra)
+	if errors.Is(err, errNotModified) {
+		return &cached.release, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if etag := header.Get("ETag"); etag != "" {
+		p.releases.put(key, releaseEntry{etag: etag, release: release, fetched: time.Now()})
+	}
+	return &release, nil
+}
+
+// apiGet fetches a GitHub API URL and decodes the JSON response into v,
+// returning the response headers. extra headers are added to the request. A 404
+// is reported as ErrNotFound and a 304 as errNotModified.
+func (p *GithubPrivateReleaseProxy) apiGet(ctx context.Context, u string, v any, extra http.Header) (http.Header, error) {
+	p.logFor(ctx).Debug("Fetching release info from GitHub API", "url", u)
+
+	// Metadata responses are small, so unlike asset downloads the whole call is bounded
+	ctx, cancel := context.WithTimeout(ctx, p.apiTimeout)
+	defer cancel()
+
+	if !p.upstream.acquire(ctx) {
+		return nil, fmt.Errorf("waiting for an upstream slot: %w", context.Cause(ctx))
+	}
+	defer p.upstream.release()
+
+	req, err := newGithubRequest(ctx, u, mediaTypeJSON)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request to GitHub API: %w", err)
+	}
+	for k, vals := range extra {
+		req.Header[k] = vals
+	}
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	p.metrics.upstream("release", start, err != nil || resp.StatusCode >= 400)
+	if err != nil {
+		return nil, fmt.Errorf("error making request to GitHub API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return resp.Header, errNotModified
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("GitHub API returned %s for %s: %w", resp.Status, u, ErrNotFound)
+	}
+	if err := checkRateLimit(resp); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, detail := readErrorBody(resp)
+		p.logFor(ctx).Debug("GitHub API error response", "url", u, "status", resp.Status, "body", body)
+		return nil, fmt.Errorf("GitHub API returned non-200 status: %s%s", resp.Status, detail)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return nil, fmt.Errorf("error decoding GitHub API response: %w", err)
+	}
+	return resp.Header, nil
+}
+
+// file requests the content of asset, adding any extra request headers. The
+// response is returned for a 200, or a 304, 206 or 416 when extra carries
+// conditions or a range.
+//
+// The request holds an upstream slot until the response body is closed.
+func (p *GithubPrivateReleaseProxy) file(ctx context.Context, asset *Asset, extra http.Header) (*http.Response, error) {
+	req, err := newGithubRequest(ctx, asset.URL, mediaTypeBinary)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request for asset: %w", err)
+	}
+	for k, v := range extra {
+		req.Header[k] = v
+	}
+
+	if !p.upstream.acquire(ctx) {
+		return nil, fmt.Errorf("waiting for an upstream slot: %w", context.Cause(ctx))
+	}
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	p.metrics.upstream("asset", start, err != nil || resp.StatusCode >= 400)
+	if err != nil {
+		p.upstream.release()
+		return nil, fmt.Errorf("error making request for asset: %w", err)
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: p.upstream.release}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNotModified, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+	default:
+		defer resp.Body.Close()
+		if err := checkRateLimit(resp); err != nil {
+			return nil, err
+		}
+		body, detail := readErrorBody(resp)
+		p.logFor(ctx).Debug("GitHub API error response", "url", asset.URL, "status", resp.Status, "body", body)
+		return nil, fmt.Errorf("GitHub API returned non-200 status for asset: %s%s", resp.Status, detail)
+	}
+	if err := p.capBody(resp); err != nil {
+		resp.Body.Close()
+		return resp, err
+	}
+	return resp, nil
+}
+
+// Release is a GitHub release and its assets, as the releases API returns it.
+type Release struct {
+	TagName string  `json:"tag_name"`
+	Name    string  `json:"name"`
+	Draft   bool    `json:"draft"`
+	Assets  []Asset `json:"assets"`
+}
+
+// Asset is one file attached to a Release.
+type Asset struct {
+	Name               string    `json:"name"`
+	ContentType        string    `json:"content_type"`
+	BrowserDownloadURL string    `json:"browser_download_url"`
+	URL                string    `json:"url"`
+	Size               int64     `json:"size"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// checkRedirect returns a redirect policy that only follows redirects to the
+// allowed hosts. An empty list follows any redirect, like the default client.
+func checkRedirect(allowed []string) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after 10 redirects")
+		}
+		// Asset downloads redirect to signed storage URLs, which reject (and must
+		// never see) the GitHub token
+		if !isGitHubHost(req.URL.Hostname()) {
+			req.Header.Del("Authorization")
+		}
+		if len(allowed) == 0 {
+			return nil
+		}
+		host := req.URL.Hostname()
+		for _, pattern := range allowed {
+			if hostMatches(host, pattern) {
+				return nil
+			}
+		}
+		return fmt.Errorf("redirect to %s is not permitted by allowedRedirectHosts", host)
+	}
+}
+
+// repoFromPath returns the owner and repo of a request on one of the
+// /{user}/{repo}/... routes. Other paths, such as /releases/latest, aren't
+// about a single repo and report false.
+func repoFromPath(u *url.URL) (owner, repo string, ok bool) {
+	segments := strings.SplitN(strings.TrimPrefix(u.EscapedPath(), "/"), "/", 3)
+	if len(segments) < 3 {
+		return "", "", false
+	}
+	// Unescape per segment like the mux does, so an encoded slash can't shift them
+	owner, err := url.PathUnescape(segments[0])
+	if err != nil {
+		return "", "", false
+	}
+	repo, err = url.PathUnescape(segments[1])
+	if err != nil {
+		return "", "", false
+	}
+	return owner, repo, true
+}
+
+// repoAllowed reports whether owner/repo matches one of allowedRepos, compared
+// case-insensitively as GitHub does. Patterns use path.Match syntax, so
+// "owner/*" covers every repo of an owner.
+func (p *GithubPrivateReleaseProxy) repoAllowed(owner, repo string) bool {
+	if len(p.allowedRepos) == 0 {
+		return true
+	}
+	name := strings.ToLower(owner + "/" + repo)
+	for _, pattern := range p.allowedRepos {
+		if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hostMatches reports whether host matches pattern. A pattern of the form
+// "*.example.com" matches any subdomain of example.com but not example.com itself.
+func hostMatches(host, pattern string) bool {
+	host = strings.ToLower(host)
+	pattern = strings.ToLower(pattern)
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok && strings.HasPrefix(suffix, ".") {
+		return strings.HasSuffix(host, suffix)
+	}
+	return host == pattern
+}
+
+// githubHosts are the hosts GithubTripper sends tokens to.
+var githubHosts = []string{"github.com", "api.github.com"}
+
+func isGitHubHost(host string) bool {
+	return slices.Contains(githubHosts, strings.ToLower(host))
+}
+
+// GithubTripper authenticates each request to a GitHub host with a token for the
+// repo in its context, then sends it with base (the token manager's transport).
+// Requests to other hosts, such as the storage URLs assets redirect to, are sent
+// without credentials.
+type GithubTripper struct {
+	tm   *TokenManager
+	base http.RoundTripper
+}
+
+func (t *GithubTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isGitHubHost(req.URL.Hostname()) {
+		if req.Header.Get("Authorization") != "" {
+			req = req.Clone(req.Context())
+			req.Header.Del("Authorization")
+		}
+		return t.base.RoundTrip(req)
+	}
+	owner, repo, ok := repoFromContext(req.Context())
+	if !ok {
+		return nil, fmt.Errorf("no repo context set on request")
+	}
+	token, err := t.tm.TokenForRepo(req.Context(), owner, repo)
+	if err != nil {
+		return nil, fmt.Errorf("error getting token: %w", err)
+	}
+	req.Header.Set("Authorization", "token "+token.AccessToken)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || req.Body != nil {
+		return resp, err
+	}
+
+	// Installation tokens are refreshed before they expire, but one can still be
+	// revoked or go stale (e.g. clock skew); retry once with a freshly minted token.
+	if !t.tm.invalidate(owner, repo) {
+		return resp, nil
+	}
+	token, err = t.tm.TokenForRepo(req.Context(), owner, repo)
+	if err != nil {
+		return resp, nil
+	}
+	resp.Body.Close()
+	retry := req.Clone(req.Context())
+	retry.Header.Set("Authorization", "token "+token.AccessToken)
+	return t.base.RoundTrip(retry)
+}
@@ -0,0 +1,758 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bmurray/pkl-proxy/gen/appconfig"
+	"golang.org/x/oauth2"
+)
+
+// fakeGitHub stands in for api.github.com and the storage hosts assets redirect
+// to: every upstream request the proxy makes is served by its mux.
+type fakeGitHub struct {
+	*httptest.Server
+	mux *http.ServeMux
+}
+
+func newFakeGitHub(t *testing.T) *fakeGitHub {
+	t.Helper()
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return &fakeGitHub{Server: srv, mux: mux}
+}
+
+// client returns an upstream client that sends requests for any host to the fake.
+func (f *fakeGitHub) client() *http.Client {
+	return &http.Client{Transport: &toServer{addr: f.Listener.Addr().String(), base: upstreamTransport(5 * time.Second)}}
+}
+
+// release serves rel as the JSON for the release API path, e.g.
+// "/repos/o/r/releases/tags/v1".
+func (f *fakeGitHub) release(path string, rel Release) {
+	f.mux.HandleFunc("GET "+path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rel)
+	})
+}
+
+// toServer rewrites every request to plain HTTP on addr, keeping its path.
+type toServer struct {
+	addr string
+	base http.RoundTripper
+}
+
+func (t *toServer) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = "http"
+	req.URL.Host = t.addr
+	return t.base.RoundTrip(req)
+}
+
+// testAsset returns an asset whose download URL is served by the fake under
+// /repos/o/r/releases/assets/{id}.
+func testAsset(id, name string, size int64) Asset {
+	return Asset{
+		Name: name,
+		URL:  "https://api.github.com/repos/o/r/releases/assets/" + id,
+		Size: size,
+	}
+}
+
+// newTestProxy returns a proxy authenticating with a personal access token and
+// talking to gh. Unset config fields get their usual defaults.
+func newTestProxy(t *testing.T, gh *fakeGitHub, config *appconfig.AppConfig) *GithubPrivateReleaseProxy {
+	t.Helper()
+	if config == nil {
+		config = &appconfig.AppConfig{}
+	}
+	if config.Token == nil && !UsesApp(config) {
+		token := "test-token"
+		config.Token = &token
+	}
+	applyDefaults(config)
+	tm, err := NewTokenManager(config, t.TempDir(), nil, gh.client())
+	if err != nil {
+		t.Fatalf("NewTokenManager: %v", err)
+	}
+	return NewGithubPrivateReleaseProxy(tm, config)
+}
+
+// newAppTokenManager returns a token manager for a GitHub App whose app token is
+// a fixed string, so installation tokens can be minted by gh. installationID
+// pins the installation when non-nil.
+func newAppTokenManager(gh *fakeGitHub, installationID *int) *TokenManager {
+	appID := 1
+	return &TokenManager{
+		client:    gh.client(),
+		app:       &githubApp{name: AppName(&appID, nil), tokens: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "app-jwt"}), installationId: installationID},
+		apps:      make(map[string]*githubApp),
+		overrides: make(map[string]oauth2.TokenSource),
+		cache:     make(map[string]oauth2.TokenSource),
+		selected:  make(map[string]bool),
+	}
+}
+
+// mintTokens makes gh hand out installation tokens "<installation>-1",
+// "<installation>-2", ... and returns the number minted so far.
+func (f *fakeGitHub) mintTokens() *atomic.Int64 {
+	var minted atomic.Int64
+	f.mux.HandleFunc("POST /app/installations/{id}/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		n := minted.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{
+			"token":      fmt.Sprintf("%s-%d", r.PathValue("id"), n),
+			"expires_at": time.Now().Add(time.Hour),
+		})
+	})
+	return &minted
+}
+
+// get sends a request through p and returns the recorded response.
+func get(p *GithubPrivateReleaseProxy, method, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	return rec
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCancelledDownloadReleasesUpstream(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.release("/repos/o/r/releases/tags/v1", Release{TagName: "v1", Assets: []Asset{testAsset("1", "tool", 0)}})
+	started := make(chan struct{})
+	aborted := make(chan struct{})
+	gh.mux.HandleFunc("GET /repos/o/r/releases/assets/1", func(w http.ResponseWriter, r *http.Request) {
+		// More than the proxy's response buffer, so the client sees it straight away
+		w.Write([]byte(strings.Repeat("x", 64<<10)))
+		w.(http.Flusher).Flush()
+		close(started)
+		<-r.Context().Done() // the rest of the asset never comes
+		close(aborted)
+	})
+
+	p := newTestProxy(t, gh, nil)
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/o/r/v1/tool", nil)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if _, err := io.ReadFull(resp.Body, make([]byte, 1024)); err != nil {
+		t.Fatalf("reading first bytes: %v", err)
+	}
+	<-started
+
+	cancel()
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream request was not cancelled with the client's")
+	}
+	waitFor(t, "the upstream slot to be released", func() bool {
+		return p.upstream.inFlight.Load() == 0 && p.upstream.queued.Load() == 0
+	})
+	waitFor(t, "the stream to finish", func() bool { return p.ActiveStreams() == 0 })
+}
+
+func TestUnauthorizedRetriesOnceWithFreshToken(t *testing.T) {
+	gh := newFakeGitHub(t)
+	minted := gh.mintTokens()
+	var mu sync.Mutex
+	var auths []string
+	gh.mux.HandleFunc("GET /repos/o/r/releases/tags/v1", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auths = append(auths, r.Header.Get("Authorization"))
+		first := len(auths) == 1
+		mu.Unlock()
+		if first {
+			http.Error(w, `{"message":"Bad credentials"}`, http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(Release{TagName: "v1", Assets: []Asset{testAsset("1", "tool", 4)}})
+	})
+	gh.mux.HandleFunc("GET /repos/o/r/releases/assets/1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tool"))
+	})
+
+	installation := 7
+	config := &appconfig.AppConfig{}
+	applyDefaults(config)
+	p := NewGithubPrivateReleaseProxy(newAppTokenManager(gh, &installation), config)
+
+	rec := get(p, http.MethodGet, "/o/r/v1/tool", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "tool" {
+		t.Fatalf("got %d %q, want 200 \"tool\"", rec.Code, rec.Body.String())
+	}
+	want := []string{"token 7-1", "token 7-2"}
+	if !slices.Equal(auths, want) {
+		t.Errorf("release requests sent Authorization %q, want %q", auths, want)
+	}
+	if n := minted.Load(); n != 2 {
+		t.Errorf("minted %d installation tokens, want 2 (the rejected one and its replacement)", n)
+	}
+}
+
+func TestGzipEncodedAssetPassesThroughVerbatim(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(strings.Repeat("amends \"pkl:Project\"\n", 100)))
+	zw.Close()
+	published := buf.Bytes()
+
+	gh := newFakeGitHub(t)
+	gh.release("/repos/o/r/releases/tags/v1", Release{TagName: "v1", Assets: []Asset{testAsset("1", "PklProject.gz", int64(len(published)))}})
+	gh.mux.HandleFunc("GET /repos/o/r/releases/assets/1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("ETag", `"abc"`)
+		w.Write(published)
+	})
+
+	srv := httptest.NewServer(newTestProxy(t, gh, nil))
+	defer srv.Close()
+	// The test's own client mustn't decompress either
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Get(srv.URL + "/o/r/v1/PklProject.gz")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if !bytes.Equal(got, published) {
+		t.Errorf("body is %d bytes, want the %d published bytes unchanged", len(got), len(published))
+	}
+	wantHeaders := http.Header{
+		"Content-Type":     {"application/octet-stream"},
+		"Content-Encoding": {"gzip"},
+		"Content-Length":   {fmt.Sprint(len(published))},
+		"Etag":             {`"abc"`},
+	}
+	for name, want := range wantHeaders {
+		if v := resp.Header.Values(name); !reflect.DeepEqual(v, want) {
+			t.Errorf("%s = %q, want %q", name, v, want)
+		}
+	}
+}
+
+func TestNonGetMethodsRejected(t *testing.T) {
+	p := newTestProxy(t, newFakeGitHub(t), nil)
+	for _, method := range []string{http.MethodPost, http.MethodPut} {
+		for _, path := range []string{"/o/r/v1/tool", "/o/r/v1", "/o/r/zipball/main", "/healthz"} {
+			rec := get(p, method, path, nil)
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Errorf("%s %s = %d, want 405", method, path, rec.Code)
+				continue
+			}
+			if allow := rec.Header().Get("Allow"); allow != "GET, HEAD" {
+				t.Errorf("%s %s Allow = %q, want \"GET, HEAD\"", method, path, allow)
+			}
+		}
+	}
+}
+
+func TestDuplicateAssetNames(t *testing.T) {
+	older := testAsset("1", "tool", 3)
+	older.UpdatedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := testAsset("2", "tool", 3)
+	newer.UpdatedAt = older.UpdatedAt.Add(time.Hour)
+	files := []Asset{newer, older}
+
+	gh := newFakeGitHub(t)
+	gh.release("/repos/o/r/releases/tags/v1", Release{TagName: "v1", Assets: files})
+	gh.mux.HandleFunc("GET /repos/o/r/releases/assets/{id}", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "id"+r.PathValue("id"))
+	})
+
+	p := newTestProxy(t, gh, nil)
+	if asset, err := p.findAsset(files, "tool"); err == nil || !strings.Contains(err.Error(), `more than one asset named "tool"`) {
+		t.Errorf("findAsset = %v, %v, want an ambiguity error", asset, err)
+	}
+	if rec := get(p, http.MethodGet, "/o/r/v1/tool", nil); rec.Code != http.StatusConflict {
+		t.Errorf("GET with duplicate assets = %d, want 409", rec.Code)
+	}
+
+	p = newTestProxy(t, gh, &appconfig.AppConfig{DuplicateAssets: "newest"})
+	if asset, err := p.findAsset(files, "tool"); err != nil || asset.URL != newer.URL {
+		t.Errorf("findAsset with duplicateAssets newest = %v, %v, want the newer asset", asset, err)
+	}
+	if rec := get(p, http.MethodGet, "/o/r/v1/tool", nil); rec.Code != http.StatusOK || rec.Body.String() != "id2" {
+		t.Errorf("GET with duplicateAssets newest = %d %q, want 200 \"id2\"", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUpstreamAcceptHeaders(t *testing.T) {
+	gh := newFakeGitHub(t)
+	var mu sync.Mutex
+	accepts := make(map[string]string) // upstream path -> last Accept
+	record := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		accepts[r.URL.Path] = r.Header.Get("Accept")
+		mu.Unlock()
+	}
+	gh.mux.HandleFunc("GET /repos/o/r/releases/tags/v1", func(w http.ResponseWriter, r *http.Request) {
+		record(w, r)
+		json.NewEncoder(w).Encode(Release{TagName: "v1", Assets: []Asset{testAsset("1", "tool", 4)}})
+	})
+	for _, path := range []string{"/repos/o/r/releases/assets/1", "/repos/o/r/contents/README.md", "/repos/o/r/zipball/v1"} {
+		gh.mux.HandleFunc("GET "+path, func(w http.ResponseWriter, r *http.Request) {
+			record(w, r)
+			io.WriteString(w, "data")
+		})
+	}
+	p := newTestProxy(t, gh, nil)
+
+	tests := []struct {
+		request  string
+		upstream string
+		want     string
+	}{
+		{"/o/r/v1/tool", "/repos/o/r/releases/tags/v1", mediaTypeJSON},
+		{"/o/r/v1/tool", "/repos/o/r/releases/assets/1", mediaTypeBinary},
+		{"/o/r/v1/tool?accept=application/json", "/repos/o/r/releases/assets/1", "application/json"},
+		{"/o/r/contents/v1/README.md", "/repos/o/r/contents/README.md", mediaTypeRaw},
+		{"/o/r/zipball/v1", "/repos/o/r/zipball/v1", mediaTypeJSON},
+	}
+	for _, tt := range tests {
+		if rec := get(p, http.MethodGet, tt.request, nil); rec.Code != http.StatusOK {
+			t.Errorf("GET %s = %d, want 200", tt.request, rec.Code)
+			continue
+		}
+		mu.Lock()
+		got := accepts[tt.upstream]
+		mu.Unlock()
+		if got != tt.want {
+			t.Errorf("GET %s sent Accept %q to %s, want %q", tt.request, got, tt.upstream, tt.want)
+		}
+	}
+}
+
+func TestHeadAssetSize(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.release("/repos/o/r/releases/tags/v1", Release{TagName: "v1", Assets: []Asset{
+		testAsset("1", "sized", 42),
+		testAsset("2", "unsized", 0),
+	}})
+	var ranges []string
+	var mu sync.Mutex
+	gh.mux.HandleFunc("GET /repos/o/r/releases/assets/{id}", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		w.Header().Set("Content-Range", "bytes 0-0/1234")
+		w.WriteHeader(http.StatusPartialContent)
+		io.WriteString(w, "x")
+	})
+
+	tests := []struct {
+		name       string
+		probe      bool
+		file       string
+		wantLength string
+		wantRanges []string
+	}{
+		{"metadata size", false, "sized", "42", nil},
+		{"metadata size wins over probing", true, "sized", "42", nil},
+		{"no size, no probe", false, "unsized", "", nil},
+		{"no size, probed", true, "unsized", "1234", []string{"bytes=0-0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranges = nil
+			p := newTestProxy(t, gh, &appconfig.AppConfig{ProbeAssetSize: tt.probe})
+			rec := get(p, http.MethodHead, "/o/r/v1/"+tt.file, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("HEAD = %d, want 200", rec.Code)
+			}
+			if got := rec.Header().Get("Content-Length"); got != tt.wantLength {
+				t.Errorf("Content-Length = %q, want %q", got, tt.wantLength)
+			}
+			if rec.Body.Len() != 0 {
+				t.Errorf("HEAD sent a %d byte body", rec.Body.Len())
+			}
+			if !slices.Equal(ranges, tt.wantRanges) {
+				t.Errorf("asset requests with Range %q, want %q", ranges, tt.wantRanges)
+			}
+		})
+	}
+}
+
+func TestReleaseRoutes(t *testing.T) {
+	gh := newFakeGitHub(t)
+	releases := map[string]Release{
+		"release/1.2.3": {TagName: "release/1.2.3", Assets: []Asset{testAsset("1", "tool", 0)}},
+		"mylib@1.2.3":   {TagName: "mylib@1.2.3", Assets: []Asset{testAsset("2", "mylib@1.2.3", 0)}},
+	}
+	gh.mux.HandleFunc("GET /repos/o/r/releases/tags/{tag...}", func(w http.ResponseWriter, r *http.Request) {
+		rel, ok := releases[r.PathValue("tag")]
+		if !ok {
+			http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(rel)
+	})
+	gh.mux.HandleFunc("GET /repos/o/r/releases/assets/{id}", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "asset "+r.PathValue("id"))
+	})
+	p := newTestProxy(t, gh, nil)
+
+	tests := []struct {
+		name     string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{"tag with a slash", "/o/r/release/1.2.3/tool", http.StatusOK, "asset 1"},
+		{"GitHub download URL", "/o/r/releases/download/release/1.2.3/tool", http.StatusOK, "asset 1"},
+		{"tag only", "/o/r/mylib@1.2.3", http.StatusOK, "asset 2"},
+		{"no matching asset", "/o/r/release/1.2.3/missing", http.StatusNotFound, "File not found in release assets\n"},
+		{"last segment is always the file", "/o/r/release/1.2.3", http.StatusNotFound, "Release \"release\" not found\n"},
+		{"unknown tag", "/o/r/v9/tool", http.StatusNotFound, "Release \"v9\" not found\n"},
+	}
+	for _, tt := range tests {
+		rec := get(p, http.MethodGet, tt.path, nil)
+		if rec.Code != tt.wantCode || rec.Body.String() != tt.wantBody {
+			t.Errorf("%s: GET %s = %d %q, want %d %q", tt.name, tt.path, rec.Code, rec.Body.String(), tt.wantCode, tt.wantBody)
+		}
+	}
+}
+
+func TestCopyAssetHeadersDropsUnlisted(t *testing.T) {
+	upstream := http.Header{
+		"Content-Type":         {"application/octet-stream"},
+		"Content-Length":       {"4"},
+		"Etag":                 {`"abc"`},
+		"Set-Cookie":           {"session=secret"},
+		"X-Github-Request-Id":  {"ABCD:1234"},
+		"Www-Authenticate":     {"Bearer"},
+		"X-Amz-Server-Side-Id": {"xyz"},
+	}
+	got := http.Header{}
+	copyAssetHeaders(got, upstream)
+	want := http.Header{
+		"Content-Type":   {"application/octet-stream"},
+		"Content-Length": {"4"},
+		"Etag":           {`"abc"`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("copyAssetHeaders copied %v, want %v", got, want)
+	}
+
+	// And end to end, through an asset download
+	gh := newFakeGitHub(t)
+	gh.release("/repos/o/r/releases/tags/v1", Release{TagName: "v1", Assets: []Asset{testAsset("1", "tool", 4)}})
+	gh.mux.HandleFunc("GET /repos/o/r/releases/assets/1", func(w http.ResponseWriter, r *http.Request) {
+		for name, values := range upstream {
+			w.Header()[name] = values
+		}
+		io.WriteString(w, "tool")
+	})
+	rec := get(newTestProxy(t, gh, nil), http.MethodGet, "/o/r/v1/tool", nil)
+	for _, name := range []string{"Set-Cookie", "X-GitHub-Request-Id", "WWW-Authenticate", "X-Amz-Server-Side-Id"} {
+		if v := rec.Header().Get(name); v != "" {
+			t.Errorf("response carries upstream %s: %q", name, v)
+		}
+	}
+	if rec.Header().Get("ETag") != `"abc"` {
+		t.Errorf("ETag = %q, want the upstream one", rec.Header().Get("ETag"))
+	}
+}
+
+func TestTaggedHandlerWithoutMatchingAsset(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.release("/repos/o/r/releases/tags/v1", Release{TagName: "v1", Assets: []Asset{testAsset("1", "notes.txt", 5)}})
+	gh.release("/repos/o/r/releases/tags/v2", Release{TagName: "v2", Assets: []Asset{testAsset("2", "r-v2.tar.gz", 5)}})
+	gh.mux.HandleFunc("GET /repos/o/r/releases/assets/2", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "tarball")
+	})
+	template := "{repo}-{tag}.tar.gz"
+
+	tests := []struct {
+		name     string
+		config   *appconfig.AppConfig
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{"no asset named after the tag", nil, "/o/r/v1", http.StatusNotFound, "Release \"v1\" has no asset named \"v1\"\n"},
+		{"nor a primary asset", &appconfig.AppConfig{PrimaryAssetTemplate: &template}, "/o/r/v1", http.StatusNotFound,
+			"Release \"v1\" has no asset named \"v1\" or \"r-v1.tar.gz\"\n"},
+		{"primary asset", &appconfig.AppConfig{PrimaryAssetTemplate: &template}, "/o/r/v2", http.StatusOK, "tarball"},
+	}
+	for _, tt := range tests {
+		rec := get(newTestProxy(t, gh, tt.config), http.MethodGet, tt.path, nil)
+		if rec.Code != tt.wantCode || rec.Body.String() != tt.wantBody {
+			t.Errorf("%s: GET %s = %d %q, want %d %q", tt.name, tt.path, rec.Code, rec.Body.String(), tt.wantCode, tt.wantBody)
+		}
+	}
+}
+
+func TestChecksumVerification(t *testing.T) {
+	const body = "tool"
+	sum := sha256.Sum256([]byte(body))
+	digest := hex.EncodeToString(sum[:])
+
+	gh := newFakeGitHub(t)
+	bodies := map[string]string{
+		"1": body,
+		"2": digest + "  tool\n",
+		"3": strings.Repeat("0", 64) + "\n",
+	}
+	gh.mux.HandleFunc("GET /repos/o/r/releases/assets/{id}", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, bodies[r.PathValue("id")])
+	})
+	tool := testAsset("1", "tool", int64(len(body)))
+	gh.release("/repos/o/r/releases/tags/good", Release{TagName: "good", Assets: []Asset{tool, testAsset("2", "tool.sha256", 0)}})
+	gh.release("/repos/o/r/releases/tags/bad", Release{TagName: "bad", Assets: []Asset{tool, testAsset("3", "tool.sha256", 0)}})
+	gh.release("/repos/o/r/releases/tags/none", Release{TagName: "none", Assets: []Asset{tool}})
+	gh.release("/repos/o/r/releases/tags/twice", Release{TagName: "twice", Assets: []Asset{tool, testAsset("2", "tool.sha256", 0), testAsset("3", "tool.sha256", 0)}})
+	p := newTestProxy(t, gh, &appconfig.AppConfig{VerifyChecksums: true})
+
+	tests := []struct {
+		tag      string
+		wantCode int
+	}{
+		{"good", http.StatusOK},
+		{"none", http.StatusOK},
+		{"bad", http.StatusBadGateway},
+		// Two checksums can't be told apart, so neither is silently skipped
+		{"twice", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		rec := get(p, http.MethodGet, "/o/r/"+tt.tag+"/tool", nil)
+		if rec.Code != tt.wantCode {
+			t.Errorf("release %q: status = %d, want %d (%s)", tt.tag, rec.Code, tt.wantCode, rec.Body.String())
+		}
+		if tt.wantCode == http.StatusOK && rec.Body.String() != body {
+			t.Errorf("release %q: body = %q, want %q", tt.tag, rec.Body.String(), body)
+		}
+	}
+}
+
+func TestMaxAssetSizeOnEveryRoute(t *testing.T) {
+	big := strings.Repeat("x", 20)
+	gh := newFakeGitHub(t)
+	gh.release("/repos/o/r/releases/tags/v1", Release{TagName: "v1", Assets: []Asset{testAsset("1", "tool", 0)}})
+	for _, path := range []string{"/repos/o/r/releases/assets/1", "/repos/o/r/contents/big.txt"} {
+		gh.mux.HandleFunc("GET "+path, func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, big) // short enough to carry a Content-Length
+		})
+	}
+	gh.mux.HandleFunc("GET /repos/o/r/zipball/v1", func(w http.ResponseWriter, r *http.Request) {
+		// Flushing first leaves the length undeclared, so the cap trips mid-stream
+		w.(http.Flusher).Flush()
+		io.WriteString(w, big)
+	})
+	maxSize := 10
+	p := newTestProxy(t, gh, &appconfig.AppConfig{MaxAssetSize: &maxSize})
+
+	for _, path := range []string{"/o/r/v1/tool", "/o/r/v1/tool.sha256", "/o/r/contents/v1/big.txt"} {
+		if rec := get(p, http.MethodGet, path, nil); rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("GET %s = %d, want 413", path, rec.Code)
+		}
+	}
+
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+	// Depending on buffering the abort cuts off the headers or the body
+	resp, err := srv.Client().Get(srv.URL + "/o/r/zipball/v1")
+	if err == nil {
+		got, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil {
+			t.Errorf("archive over maxAssetSize read %d bytes cleanly, want the response aborted", len(got))
+		}
+	}
+}
+
+func TestAssetRedirectDropsAuthorization(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.release("/repos/o/r/releases/tags/v1", Release{TagName: "v1", Assets: []Asset{testAsset("1", "tool", 4)}})
+	var mu sync.Mutex
+	auth := make(map[string][]string) // Authorization headers seen, by leg
+	seen := func(leg string, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		auth[leg] = append(auth[leg], r.Header.Get("Authorization"))
+	}
+	gh.mux.HandleFunc("GET /repos/o/r/releases/assets/1", func(w http.ResponseWriter, r *http.Request) {
+		seen("api", r)
+		http.Redirect(w, r, "https://storage.example.com/signed/1?sig=abc", http.StatusFound)
+	})
+	gh.mux.HandleFunc("GET /signed/1", func(w http.ResponseWriter, r *http.Request) {
+		seen("storage", r)
+		io.WriteString(w, "tool")
+	})
+	p := newTestProxy(t, gh, nil)
+
+	rec := get(p, http.MethodGet, "/o/r/v1/tool", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "tool" {
+		t.Fatalf("GET = %d %q, want 200 \"tool\"", rec.Code, rec.Body.String())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"token test-token"}; !slices.Equal(auth["api"], want) {
+		t.Errorf("GitHub saw Authorization %q, want %q", auth["api"], want)
+	}
+	if want := []string{""}; !slices.Equal(auth["storage"], want) {
+		t.Errorf("storage host saw Authorization %q, want none", auth["storage"])
+	}
+
+	// The client only carries over headers set before the transport ran, so
+	// check the redirect policy strips a token it is handed as well
+	for host, want := range map[string]string{"storage.example.com": "", "api.github.com": "token t"} {
+		req, _ := http.NewRequest(http.MethodGet, "https://"+host+"/signed/1", nil)
+		req.Header.Set("Authorization", "token t")
+		if err := checkRedirect(nil)(req, []*http.Request{req}); err != nil {
+			t.Fatalf("checkRedirect(%s): %v", host, err)
+		}
+		if got := req.Header.Get("Authorization"); got != want {
+			t.Errorf("redirect to %s kept Authorization %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestAllowedRepos(t *testing.T) {
+	gh := newFakeGitHub(t)
+	var calls atomic.Int64
+	gh.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.NotFound(w, r)
+	})
+	gh.mux.HandleFunc("GET /repos/{owner}/{repo}/releases/tags/v1", func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		json.NewEncoder(w).Encode(Release{TagName: "v1", Assets: []Asset{testAsset("1", "tool", 4)}})
+	})
+	gh.mux.HandleFunc("GET /repos/o/r/releases/assets/1", func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		io.WriteString(w, "tool")
+	})
+	p := newTestProxy(t, gh, &appconfig.AppConfig{AllowedRepos: []string{"acme/*", "other/lib"}})
+
+	tests := []struct {
+		name     string
+		path     string
+		wantCode int
+	}{
+		{"owner pattern", "/acme/tool/v1/tool", http.StatusOK},
+		{"exact repo", "/other/lib/v1/tool", http.StatusOK},
+		{"case-insensitive", "/ACME/Tool/v1/tool", http.StatusOK},
+		{"exact repo is case-insensitive", "/Other/LIB/v1/tool", http.StatusOK},
+		{"other repo of a listed owner", "/other/app/v1/tool", http.StatusForbidden},
+		{"unlisted owner", "/evil/repo/v1/tool", http.StatusForbidden},
+		{"encoded slash in owner", "/acme%2Ftool/x/v1/tool", http.StatusForbidden},
+		{"encoded slash in repo", "/other/lib%2Fx/v1/tool", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		before := calls.Load()
+		rec := get(p, http.MethodGet, tt.path, nil)
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s: GET %s = %d, want %d", tt.name, tt.path, rec.Code, tt.wantCode)
+		}
+		if n := calls.Load() - before; tt.wantCode == http.StatusForbidden && n != 0 {
+			t.Errorf("%s: GET %s made %d upstream calls, want none", tt.name, tt.path, n)
+		}
+	}
+}
+
+func TestReconfiguredCarriesOnFromRunningProxy(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.release("/repos/o/r/releases/tags/v1", Release{TagName: "v1", Assets: []Asset{testAsset("1", "tool", 0)}})
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	gh.mux.HandleFunc("GET /repos/o/r/releases/assets/1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 64<<10)))
+		w.(http.Flusher).Flush()
+		close(started)
+		select {
+		case <-finish:
+		case <-r.Context().Done():
+		}
+	})
+	inFlight := 4
+	p := newTestProxy(t, gh, &appconfig.AppConfig{Metrics: true, MaxInFlightRequests: &inFlight, MaxConcurrentUpstream: 4})
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL + "/o/r/v1/tool")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	<-started
+
+	// Reload with room for one upstream request, which the download holds
+	config := &appconfig.AppConfig{Metrics: true, MaxInFlightRequests: &inFlight, MaxConcurrentUpstream: 1}
+	applyDefaults(config)
+	next := p.Reconfigured(config)
+	if n := next.ActiveStreams(); n != 1 {
+		t.Errorf("ActiveStreams after reload = %d, want the running download", n)
+	}
+	if next.limiter != p.limiter || next.limiter.inFlight.Load() != 1 {
+		t.Errorf("request limiter was replaced or lost its count (%d in flight, want 1)", next.limiter.inFlight.Load())
+	}
+	if next.metrics != p.metrics {
+		t.Error("metrics were recreated, resetting every counter")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if next.upstream.acquire(ctx) {
+		next.upstream.release()
+		t.Fatal("reloaded proxy started a second upstream request with maxConcurrentUpstream 1")
+	}
+
+	close(finish)
+	io.Copy(io.Discard, resp.Body)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !next.upstream.acquire(ctx) {
+		t.Fatal("upstream slot wasn't freed once the download finished")
+	}
+	next.upstream.release()
+}
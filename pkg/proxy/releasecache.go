@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// releaseCache remembers decoded release metadata keyed by API URL (and so by
+// owner/repo/tag), along with the ETag GitHub sent for it. Entries are revalidated
+// with If-None-Match, which doesn't count against the rate limit when GitHub
+// answers 304, and dropped once they are older than ttl.
+type releaseCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]releaseEntry
+}
+
+type releaseEntry struct {
+	etag    string
+	release Release
+	fetched time.Time
+}
+
+// newReleaseCache returns a cache whose entries live for ttl, or nil if ttl is
+// not positive. A nil cache never holds anything.
+func newReleaseCache(ttl time.Duration) *releaseCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &releaseCache{ttl: ttl, entries: make(map[string]releaseEntry)}
+}
+
+func (c *releaseCache) get(key string) (releaseEntry, bool) {
+	if c == nil {
+		return releaseEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if ok && time.Since(e.fetched) > c.ttl {
+		delete(c.entries, key)
+		return releaseEntry{}, false
+	}
+	return e, ok
+}
+
+func (c *releaseCache) put(key string, e releaseEntry) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = e
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReleaseCacheIgnoresOwnerRepoCase(t *testing.T) {
+	gh := newFakeGitHub(t)
+	var fetches, revalidations atomic.Int64
+	gh.mux.HandleFunc("GET /repos/{owner}/{repo}/releases/tags/{tag}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("owner") != "acme" || r.PathValue("repo") != "tool" {
+			t.Errorf("requested %s, want owner and repo lowercased", r.URL.Path)
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			revalidations.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(Release{TagName: r.PathValue("tag")})
+	})
+	p := newTestProxy(t, gh, nil)
+
+	for _, name := range [][2]string{{"Acme", "Tool"}, {"acme", "tool"}, {"ACME", "TOOL"}} {
+		ctx := withRepo(context.Background(), name[0], name[1])
+		release, err := p.release(ctx, name[0], name[1], "tags", "V1.0")
+		if err != nil {
+			t.Fatalf("release(%s/%s): %v", name[0], name[1], err)
+		}
+		if release.TagName != "V1.0" {
+			t.Errorf("release(%s/%s) tag = %q, want the tag's case kept", name[0], name[1], release.TagName)
+		}
+	}
+	if len(p.releases.entries) != 1 {
+		t.Errorf("release cache has %d entries, want 1 shared by every spelling", len(p.releases.entries))
+	}
+	if fetches.Load() != 1 || revalidations.Load() != 2 {
+		t.Errorf("got %d fetches and %d revalidations, want 1 and 2", fetches.Load(), revalidations.Load())
+	}
+}
+
+func TestTokenCacheIgnoresOwnerRepoCase(t *testing.T) {
+	gh := newFakeGitHub(t)
+	minted := gh.mintTokens()
+	lookups := gh.installations(map[string]Installation{"acme/tool": testInstallation(11, "acme", "all")})
+	tm := newAppTokenManager(gh, nil)
+
+	for _, name := range [][2]string{{"Acme", "Tool"}, {"acme", "tool"}} {
+		if _, err := tm.TokenForRepo(context.Background(), name[0], name[1]); err != nil {
+			t.Fatalf("TokenForRepo(%s/%s): %v", name[0], name[1], err)
+		}
+	}
+	if len(tm.cache) != 1 || tm.cache["acme"] == nil {
+		t.Errorf("token cache keys = %v, want just \"acme\"", tm.cache)
+	}
+	if lookups("acme/tool") != 1 || minted.Load() != 1 {
+		t.Errorf("got %d installation lookups and %d tokens minted, want 1 each", lookups("acme/tool"), minted.Load())
+	}
+}
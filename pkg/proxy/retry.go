@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// dnsRetries is how many times a request is retried after a DNS failure.
+	dnsRetries = 3
+	// dnsRetryBackoff is the wait before the first DNS retry; it doubles each time.
+	dnsRetryBackoff = 250 * time.Millisecond
+)
+
+// dnsRetryTransport retries requests whose host lookup failed, which happens
+// transiently on freshly booted machines before DNS is fully up. Other errors
+// are returned immediately.
+type dnsRetryTransport struct {
+	base http.RoundTripper
+}
+
+func (t *dnsRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := dnsRetryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		var dnsErr *net.DNSError
+		if err == nil || !errors.As(err, &dnsErr) || attempt == dnsRetries || req.Body != nil {
+			return resp, err
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, fmt.Errorf("%w (gave up retrying DNS: %w)", err, req.Context().Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// retryBackoff is the wait before the first retry of a failed upstream request;
+// it doubles each time, with jitter.
+const retryBackoff = 500 * time.Millisecond
+
+// retryTransport retries GitHub requests that failed with a 5xx or a connection
+// error, up to maxAttempts in total. Other statuses, such as 401 and 404, are
+// deterministic and returned as is. DNS failures are left to the
+// dnsRetryTransport beneath it, so their retries aren't multiplied by these.
+type retryTransport struct {
+	base        http.RoundTripper
+	maxAttempts int
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if attempt >= t.maxAttempts || req.Body != nil || !retryable(resp, err) || req.Context().Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		// Jitter spreads out the retries of requests that failed together
+		wait := backoff/2 + rand.N(backoff)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		var dnsErr *net.DNSError
+		return !errors.As(err, &dnsErr)
+	}
+	return resp.StatusCode >= 500
+}
@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// roundTripFunc adapts a function to http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRetryTransportLeavesDNSFailuresToDNSRetry(t *testing.T) {
+	attempts := 0
+	rt := &retryTransport{
+		base: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			attempts++
+			return nil, &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "api.github.com", IsNotFound: true}}
+		}),
+		maxAttempts: 3,
+	}
+	req, _ := http.NewRequest(http.MethodGet, "https://api.github.com/", nil)
+	_, err := rt.RoundTrip(req)
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) {
+		t.Fatalf("RoundTrip error = %v, want the DNS error", err)
+	}
+	if attempts != 1 {
+		t.Errorf("a DNS failure was tried %d times, want 1; dnsRetryTransport does its retries", attempts)
+	}
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bmurray/pkl-proxy/gen/appconfig"
+)
+
+// TraceCurl enables logging every upstream request as a curl command.
+var TraceCurl bool
+
+// defaultUpstreamTimeout bounds connecting to GitHub and waiting for its
+// response headers when no upstreamTimeout is configured.
+const defaultUpstreamTimeout = 30 * time.Second
+
+// upstreamTransport returns the base transport for every call to GitHub. timeout
+// bounds dialing, the TLS handshake and the wait for response headers, but not
+// reading the body, so slow downloads of large assets aren't cut off.
+// Compression is left off, so an asset stored gzip-encoded reaches the client
+// as the exact published bytes, with its Content-Encoding, rather than being
+// decompressed on the way through.
+func upstreamTransport(timeout time.Duration) http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DisableCompression = true
+	t.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+	t.TLSHandshakeTimeout = timeout
+	t.ResponseHeaderTimeout = timeout
+
+	var rt http.RoundTripper = t
+	if TraceCurl {
+		rt = &curlTracer{base: rt}
+	}
+	return &dnsRetryTransport{base: rt}
+}
+
+// upstreamClient returns a client for GitHub API calls, shared by the token
+// manager and (through its transport) the proxy. See upstreamTransport for timeout.
+func upstreamClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: upstreamTransport(timeout)}
+}
+
+// NewUpstreamClient returns the client for GitHub calls described by config's
+// upstreamTimeout and maxUpstreamAttempts, for passing to NewTokenManager.
+func NewUpstreamClient(config *appconfig.AppConfig) *http.Client {
+	client := upstreamClient(DurationOr(&config.UpstreamTimeout, defaultUpstreamTimeout))
+	client.Transport = &retryTransport{base: client.Transport, maxAttempts: config.MaxUpstreamAttempts}
+	return client
+}
+
+// curlTracer prints each request it sends as an equivalent curl command, with
+// the credential replaced by $GITHUB_TOKEN so the command can be shared.
+type curlTracer struct {
+	base http.RoundTripper
+}
+
+func (t *curlTracer) RoundTrip(req *http.Request) (*http.Response, error) {
+	fmt.Fprintln(os.Stderr, curlCommand(req))
+	return t.base.RoundTrip(req)
+}
+
+func curlCommand(req *http.Request) string {
+	var b strings.Builder
+	b.WriteString("curl -sSL")
+	if req.Method != http.MethodGet {
+		b.WriteString(" -X " + req.Method)
+	}
+	for _, name := range slices.Sorted(maps.Keys(req.Header)) {
+		for _, value := range req.Header[name] {
+			if name == "Authorization" {
+				scheme, _, _ := strings.Cut(value, " ")
+				b.WriteString(fmt.Sprintf(` -H "Authorization: %s $GITHUB_TOKEN"`, scheme))
+				continue
+			}
+			b.WriteString(" -H " + shellQuote(name+": "+value))
+		}
+	}
+	b.WriteString(" " + shellQuote(req.URL.String()))
+	return b.String()
+}
+
+// shellQuote single-quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,72 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunForwardsInterruptToCommand(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"token":"t","listenAddress":"localhost:0"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := helperCommand("run", configDirEnv+"="+dir)
+	// Its own process group stands in for the terminal's foreground group
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	var output []string
+	waitForLine := func(want string) {
+		t.Helper()
+		timeout := time.After(10 * time.Second)
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					t.Fatalf("output ended before %q:\n%s", want, strings.Join(output, "\n"))
+				}
+				output = append(output, line)
+				if line == want {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("no %q in output:\n%s", want, strings.Join(output, "\n"))
+			}
+		}
+	}
+	waitForLine("child ready")
+
+	// Ctrl+C signals every process in the foreground group
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	waitForLine("child got interrupt")
+	for line := range lines {
+		output = append(output, line)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("run exited with %v, want a clean shutdown; output:\n%s", err, strings.Join(output, "\n"))
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// statusEvent is one lifecycle event written to the status socket as a JSON line.
+type statusEvent struct {
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"`
+	Address string    `json:"address,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// statusSocket publishes lifecycle events (starting, ready, reloaded, shutting
+// down, stopped) on a unix socket so supervisors don't have to scrape logs.
+// Clients that connect late are sent the events they missed first.
+type statusSocket struct {
+	path string
+	ln   net.Listener
+
+	mu      sync.Mutex
+	history []statusEvent
+	conns   map[net.Conn]struct{}
+}
+
+func newStatusSocket(path string) (*statusSocket, error) {
+	// A socket file left behind by a previous run would make Listen fail
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("opening status socket: %w", err)
+	}
+	s := &statusSocket{path: path, ln: ln, conns: make(map[net.Conn]struct{})}
+	go s.accept()
+	return s, nil
+}
+
+func (s *statusSocket) accept() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		for _, ev := range s.history {
+			writeEvent(conn, ev)
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+	}
+}
+
+// emit sends an event to every connected client. A nil statusSocket is a no-op,
+// so callers don't need to check whether the socket is configured.
+func (s *statusSocket) emit(event, address, message string) {
+	if s == nil {
+		return
+	}
+	ev := statusEvent{Event: event, Time: time.Now(), Address: address, Message: message}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history = append(s.history, ev)
+	for conn := range s.conns {
+		if err := writeEvent(conn, ev); err != nil {
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+}
+
+func writeEvent(conn net.Conn, ev statusEvent) error {
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	return json.NewEncoder(conn).Encode(ev)
+}
+
+// Close disconnects all clients and removes the socket file.
+func (s *statusSocket) Close() {
+	if s == nil {
+		return
+	}
+	s.ln.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	os.Remove(s.path)
+}
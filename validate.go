@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/bmurray/pkl-proxy/gen/appconfig"
+	"github.com/bmurray/pkl-proxy/pkg/proxy"
+)
+
+// cmdValidateConfig checks the config without starting the server, printing
+// one line per checked field.
+func cmdValidateConfig() error {
+	configDir, err := findConfigDir()
+	if err != nil {
+		return err
+	}
+	config, err := proxy.LoadConfig(configDir)
+	if err != nil {
+		report("config", err)
+		return fmt.Errorf("config is invalid")
+	}
+	report("config", nil)
+
+	problems := 0
+	check := func(field string, err error) {
+		report(field, err)
+		if err != nil {
+			problems++
+		}
+	}
+	if proxy.UsesApp(config) {
+		check("privateKey", validatePrivateKey(configDir, config))
+	}
+	check("appId/clientId", validateAppIdentity(config))
+	for i, app := range config.Apps {
+		check(fmt.Sprintf("apps[%d]", i), validateApp(configDir, app))
+	}
+	if len(config.AllowedRepos) > 0 {
+		check("allowedRepos", validateRepoPatterns(config.AllowedRepos))
+	}
+	check("listenAddress", validateListenAddress(config.ListenAddress))
+	if config.LoopbackOnly {
+		check("loopbackOnly", checkLoopback(config.ListenAddress))
+	}
+	if proxy.TLSEnabled(config) {
+		_, err := tls.LoadX509KeyPair(*config.TlsCertFile, *config.TlsKeyFile)
+		check("tlsCertFile/tlsKeyFile", err)
+		_, err = proxy.ServerTLSConfig(config)
+		check("tlsMinVersion/tlsCipherSuites", err)
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("config has %d problem(s)", problems)
+	}
+	return nil
+}
+
+func report(field string, err error) {
+	if err != nil {
+		fmt.Printf("  %-16s FAIL  %v\n", field, err)
+		return
+	}
+	fmt.Printf("  %-16s ok\n", field)
+}
+
+func validatePrivateKey(configDir string, cfg *appconfig.AppConfig) error {
+	data, err := proxy.ReadPrivateKey(configDir, cfg)
+	if err != nil {
+		return err
+	}
+	return checkRSAKey(data)
+}
+
+func validateApp(configDir string, app *appconfig.GithubApp) error {
+	if len(app.Owners) == 0 {
+		return errors.New("owners is empty")
+	}
+	if (app.AppId == nil) == (app.ClientId == nil) {
+		return errors.New("set exactly one of appId and clientId")
+	}
+	data, err := proxy.ReadKeyFile(configDir, app.PrivateKey)
+	if err != nil {
+		return err
+	}
+	return checkRSAKey(data)
+}
+
+// checkRSAKey reports whether data is a PEM-encoded RSA private key, as GitHub
+// issues for apps.
+func checkRSAKey(data []byte) error {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return errors.New("not a PEM-encoded key")
+	}
+	if _, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("not an RSA private key: %w", err)
+	}
+	if _, ok := key.(*rsa.PrivateKey); !ok {
+		return fmt.Errorf("key is %T, GitHub Apps use RSA keys", key)
+	}
+	return nil
+}
+
+func validateAppIdentity(cfg *appconfig.AppConfig) error {
+	switch {
+	case cfg.AppId != nil && cfg.ClientId != nil:
+		return errors.New("both are set; set exactly one (appId currently takes precedence)")
+	case cfg.AppId == nil && cfg.ClientId == nil && cfg.Token == nil && len(cfg.Apps) == 0:
+		return errors.New("neither is set; set exactly one, or apps or token/tokenFile")
+	}
+	return nil
+}
+
+// validateRepoPatterns checks that each allowedRepos entry is an owner/repo
+// pattern that path.Match accepts.
+func validateRepoPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		owner, repo, ok := strings.Cut(pattern, "/")
+		if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+			return fmt.Errorf("%q is not an owner/repo pattern", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func validateListenAddress(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, set at link time by release builds:
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=abc1234 -X main.date=2025-01-01T00:00:00Z"
+//
+// Builds without them fall back to what the Go toolchain embedded in the binary.
+var (
+	version = "devel" // pkl-proxy release version
+	commit  = ""      // git commit the binary was built from
+	date    = ""      // build (or, failing that, commit) time, RFC 3339
+)
+
+func init() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	// "go install ...@v1.2.3" records the module version; local builds report "(devel)"
+	if version == "devel" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+	var revision string
+	var modified bool
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value == "true"
+		case "vcs.time":
+			if date == "" {
+				date = s.Value
+			}
+		}
+	}
+	if commit == "" && revision != "" {
+		commit = revision
+		if modified {
+			commit += "-dirty"
+		}
+	}
+}
+
+func cmdVersion() {
+	fmt.Printf("pkl-proxy %s\n", version)
+	fmt.Printf("Commit:     %s\n", orUnknown(commit))
+	fmt.Printf("Built:      %s\n", orUnknown(date))
+	fmt.Printf("Go version: %s\n", runtime.Version())
+	fmt.Printf("Platform:   %s/%s\n", runtime.GOOS, runtime.GOARCH)
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}